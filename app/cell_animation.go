@@ -0,0 +1,100 @@
+package app
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Waves of cells moving to and from the center travel outwards, one wave is this long.
+const animationWaveLength = 5.0
+
+// All motions repeat after this many seconds, their frequencies are rounded to a whole number of cycles
+// per period. Animation clock is wrapped to the period before it's used, so it doesn't lose precision
+// when it's converted for GPU, and cells move the same way as if it wasn't wrapped.
+const animationPeriod = 600.0
+
+// Time of animation clock in seconds.
+var animationTime float64
+
+// SetAnimationTime sets time of animation clock, animated cells are placed as they are at that time.
+// Cells with the same settings are always placed the same way at the same time, so exports can be reproduced.
+func SetAnimationTime(time float64) {
+	animationTime = time
+}
+
+// GetAnimationTime returns time of animation clock in seconds.
+func GetAnimationTime() float64 {
+	return animationTime
+}
+
+// getAnimationClock returns time of animation clock wrapped to animationPeriod.
+func getAnimationClock() float64 {
+	return animationTime - math.Floor(animationTime / animationPeriod) * animationPeriod
+}
+
+// getMotionFrequency returns frequency of motion with speed in cycles per second, rounded to a whole
+// number of cycles per animationPeriod. Cell vertex shader rounds it the same way.
+func getMotionFrequency(speed float64) float64 {
+	return math.Floor(speed * animationPeriod + 0.5) / animationPeriod
+}
+
+// IsAnimated reports whether cells with settings move with animation clock.
+func IsAnimated(settings *AnimationSettings) bool {
+	return settings.Orbit.Speed != 0 || settings.Breathe.Speed != 0 || settings.Wave.Speed != 0 || settings.Spin.Speed != 0
+}
+
+// getAnimationMotions returns all the motions of settings, in the order they're stored in share codes.
+func getAnimationMotions(settings *AnimationSettings) []*AnimationMotion {
+	return []*AnimationMotion{&settings.Orbit, &settings.Breathe, &settings.Wave, &settings.Spin}
+}
+
+// getCellPhase returns random (but fixed) phase of cell's animation in [0, 1) range. It's part of
+// instance data of cells, so they're animated the same way on GPU.
+func getCellPhase(cell *Cell) float32 {
+	return float32(getCellRandom(cell))
+}
+
+// fract returns fractional part of x.
+func fract(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+// animateCellMatrix returns model matrix of cell with phase moved by animation at time, wrapped to animationPeriod.
+// Cells are animated the same way by cell vertex shader. Motions are applied in cell's space first and then in world space,
+// each with its own offset of the phase, so they aren't in sync.
+func animateCellMatrix(matrix mgl32.Mat4, phase float32, settings *AnimationSettings, time float64) mgl32.Mat4 {
+	if spin := &settings.Spin; spin.Speed != 0 {
+		variation := 1.0 + spin.Amplitude * (2.0 * fract(float64(phase) * 7.0) - 1.0)
+		matrix = matrix.Mul4(mgl32.HomogRotate3DY(float32(2.0 * math.Pi * fract(getMotionFrequency(spin.Speed * variation) * time))))
+	}
+	if breathe := &settings.Breathe; breathe.Speed != 0 {
+		scale := float32(math.Max(0.0, 1.0 + breathe.Amplitude * math.Sin(2.0 * math.Pi * (getMotionFrequency(breathe.Speed) * time + float64(phase)))))
+		matrix = matrix.Mul4(mgl32.Scale3D(scale, scale, scale))
+	}
+	if wave := &settings.Wave; wave.Speed != 0 {
+		position := matrix.Col(3).Vec3()
+		if distance := float64(position.Len()); distance > 1e-6 {
+			offset := wave.Amplitude * math.Sin(2.0 * math.Pi * (getMotionFrequency(wave.Speed) * time - distance / animationWaveLength))
+			matrix.SetCol(3, position.Mul(float32(1.0 + offset / distance)).Vec4(1.0))
+		}
+	}
+	if orbit := &settings.Orbit; orbit.Speed != 0 {
+		variation := 1.0 + orbit.Amplitude * (2.0 * fract(float64(phase) * 13.0) - 1.0)
+		matrix = mgl32.HomogRotate3DY(float32(2.0 * math.Pi * fract(getMotionFrequency(orbit.Speed * variation) * time))).Mul4(matrix)
+	}
+	return matrix
+}
+
+// animateCellMatrices moves cells by animation of settings at time of animation clock.
+func animateCellMatrices(matrices []mgl32.Mat4, cells []Cell, settings *AnimationSettings) {
+	if !IsAnimated(settings) {
+		return
+	}
+	time := getAnimationClock()
+	forEachCellParallel(len(matrices), func(start, end int) {
+		for i := start; i < end; i++ {
+			matrices[i] = animateCellMatrix(matrices[i], getCellPhase(&cells[i]), settings, time)
+		}
+	})
+}
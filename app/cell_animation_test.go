@@ -0,0 +1,36 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestAnimationClockWraps(t *testing.T) {
+	defer SetAnimationTime(GetAnimationTime())
+	for time, expected := range map[float64]float64{0.0: 0.0, 12.5: 12.5, animationPeriod * 3.0 + 12.5: 12.5, -animationPeriod + 12.5: 12.5} {
+		SetAnimationTime(time)
+		if clock := getAnimationClock(); clock < expected - 1e-9 || clock > expected + 1e-9 {
+			t.Errorf("time %v is wrapped to %v, expected %v", time, clock, expected)
+		}
+	}
+}
+
+func TestAnimationRepeatsAfterPeriod(t *testing.T) {
+	settings := AnimationSettings{
+		Orbit:   AnimationMotion{0.37, 0.5},
+		Breathe: AnimationMotion{-1.13, 0.3},
+		Wave:    AnimationMotion{0.71, 2.0},
+		Spin:    AnimationMotion{1.9, 0.8},
+	}
+	matrix := mgl32.Translate3D(3.0, 1.0, -7.0).Mul4(mgl32.Scale3D(0.5, 1.0, 2.0))
+	for _, phase := range []float32{0.0, 0.123, 0.77} {
+		for _, time := range []float64{0.0, 1.7, 42.0} {
+			expected := animateCellMatrix(matrix, phase, &settings, time)
+			repeated := animateCellMatrix(matrix, phase, &settings, time + animationPeriod * 5.0)
+			if !expected.ApproxEqualThreshold(repeated, 1e-3) {
+				t.Errorf("cell with phase %v at time %v moves to %v, after periods to %v", phase, time, expected, repeated)
+			}
+		}
+	}
+}
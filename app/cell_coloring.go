@@ -0,0 +1,408 @@
+package app
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// ColoringSettings select how colors of the palette are assigned to cells and parameters of the coloring.
+// Parameters missing from the map have their default values.
+type ColoringSettings struct {
+	Name       string
+	Parameters map[string]float64
+}
+
+// CellColoring assigns colors of the palette to cells. Cells get palette coordinates, their integer part
+// selects color of the palette and fractional part blends it with the next color.
+type CellColoring interface {
+	Name() string
+	// Parameters are described the same way as parameters of distributions, they can depend on palette size.
+	Parameters(paletteSize int) []DistributionParameter
+	// UsesPositions reports whether coloring needs model matrices of cells, i.e. whether colors
+	// change when cells move.
+	UsesPositions() bool
+	// Assign fills coords with palette coordinates of cells, in [0, paletteSize - 1] range.
+	// Matrices are nil, unless the coloring uses positions.
+	Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64)
+}
+
+// Name of the coloring used when none is specified, it's the original look of iris.
+const DefaultColoringName = "random"
+
+var cellColorings = []CellColoring{
+	randomColoring{},
+	weightedColoring{},
+	gradientColoring{"radius", false, func(position mgl32.Vec3) float64 { return float64(position.Len()) }},
+	gradientColoring{"azimuth", true, func(position mgl32.Vec3) float64 {
+		return math.Atan2(float64(position[0]), float64(position[2])) / (2.0 * math.Pi) + 0.5
+	}},
+	gradientColoring{"height", false, func(position mgl32.Vec3) float64 { return float64(position[1]) }},
+	noiseColoring{},
+	clusterColoring{},
+}
+
+// GetCellColoring returns coloring with name, or the default coloring if there's no coloring with such name.
+func GetCellColoring(name string) CellColoring {
+	for _, coloring := range cellColorings {
+		if coloring.Name() == name {
+			return coloring
+		}
+	}
+	return cellColorings[0]
+}
+
+// GetNextCellColoring returns name of coloring following the one with name, wrapping around after the last one.
+func GetNextCellColoring(name string) string {
+	for i, coloring := range cellColorings {
+		if coloring.Name() == name {
+			return cellColorings[(i + 1) % len(cellColorings)].Name()
+		}
+	}
+	return cellColorings[0].Name()
+}
+
+// GetColoringParameters returns values of all the parameters of coloring selected in settings,
+// for palette with paletteSize colors. Missing values are filled with defaults, all the values
+// are clamped to their range.
+func GetColoringParameters(settings *ColoringSettings, paletteSize int) map[string]float64 {
+	coloring := GetCellColoring(settings.Name)
+	parameters := make(map[string]float64)
+	for _, parameter := range coloring.Parameters(paletteSize) {
+		value, ok := settings.Parameters[parameter.Name]
+		if !ok || math.IsNaN(value) {
+			value = parameter.Default
+		}
+		parameters[parameter.Name] = clamp(value, parameter.Min, parameter.Max)
+	}
+	return parameters
+}
+
+// copyColoringSettings returns deep copy of settings.
+func copyColoringSettings(settings ColoringSettings) ColoringSettings {
+	parameters := make(map[string]float64, len(settings.Parameters))
+	for name, value := range settings.Parameters {
+		parameters[name] = value
+	}
+	settings.Parameters = parameters
+	return settings
+}
+
+// isSameColoring reports whether settings a and b select the same coloring with the same parameters.
+func isSameColoring(a, b *ColoringSettings) bool {
+	if a.Name != b.Name || len(a.Parameters) != len(b.Parameters) {
+		return false
+	}
+	for name, value := range a.Parameters {
+		if other, ok := b.Parameters[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// getPaletteCoords returns palette coordinates of cells assigned by coloring in settings,
+// see CellColoring. Matrices are needed only if the coloring uses positions.
+func getPaletteCoords(cells []Cell, matrices []mgl32.Mat4, settings *ColoringSettings, seed int64, paletteSize int) []float64 {
+	coords := make([]float64, len(cells))
+	coloring := GetCellColoring(settings.Name)
+	coloring.Assign(coords, cells, matrices, seed, paletteSize, GetColoringParameters(settings, paletteSize))
+	return coords
+}
+
+// getPaletteColor returns color of palette at palette coordinate.
+func getPaletteColor(colorPalette []mgl32.Vec4, coord float64) mgl32.Vec4 {
+	index := int(clamp(math.Floor(coord), 0, float64(len(colorPalette) - 1)))
+	color := colorPalette[index]
+	if t := float32(coord - float64(index)); t > 0 && index + 1 < len(colorPalette) {
+		color = color.Mul(1.0 - t).Add(colorPalette[index + 1].Mul(t))
+	}
+	return color
+}
+
+// getBlendedCoord returns palette coordinate with bands between colors narrowed by blend. Colors
+// are blended over whole bands when it's 1, they don't blend at all when it's 0.
+func getBlendedCoord(coord, blend float64) float64 {
+	index := math.Floor(coord)
+	t := coord - index
+	if blend <= 0 {
+		return index + math.Floor(t + 0.5)
+	}
+	return index + clamp((t - 0.5) / blend + 0.5, 0, 1)
+}
+
+// getCellRandom returns random (but fixed) value of cell in [0, 1) range. It's taken from high bits
+// of color index, so it doesn't depend on the color random coloring gives the cell.
+func getCellRandom(cell *Cell) float64 {
+	return float64(cell.colorIndex >> 11) / float64(1 << 52)
+}
+
+// isHiddenCell reports whether cell with model matrix is hidden, hidden cells have no volume.
+func isHiddenCell(matrix *mgl32.Mat4) bool {
+	return matrix.Mat3().Det() == 0
+}
+
+// randomColoring gives cells random colors of the palette, every color is equally likely.
+type randomColoring struct{}
+
+func (randomColoring) Name() string {
+	return DefaultColoringName
+}
+
+func (randomColoring) Parameters(paletteSize int) []DistributionParameter {
+	return nil
+}
+
+func (randomColoring) UsesPositions() bool {
+	return false
+}
+
+func (randomColoring) Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64) {
+	for i := range coords {
+		coords[i] = float64(cells[i].colorIndex % paletteSize)
+	}
+}
+
+// weightedColoring gives cells random colors of the palette, colors are as likely as their weights.
+type weightedColoring struct{}
+
+func (weightedColoring) Name() string {
+	return "weighted"
+}
+
+func (weightedColoring) Parameters(paletteSize int) []DistributionParameter {
+	parameters := make([]DistributionParameter, paletteSize)
+	for i := range parameters {
+		parameters[i] = DistributionParameter{"Weight" + strconv.Itoa(i + 1), 1.0, 0.0, 10.0}
+	}
+	return parameters
+}
+
+func (weightedColoring) UsesPositions() bool {
+	return false
+}
+
+func (weightedColoring) Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64) {
+	cumulative := make([]float64, paletteSize)
+	sum := 0.0
+	for i := range cumulative {
+		sum += parameters["Weight" + strconv.Itoa(i + 1)]
+		cumulative[i] = sum
+	}
+	// Colors are equally likely when all the weights are zero.
+	if sum == 0 {
+		for i := range cumulative {
+			cumulative[i] = float64(i + 1)
+		}
+		sum = float64(paletteSize)
+	}
+	for i := range coords {
+		value := getCellRandom(&cells[i]) * sum
+		index := sort.Search(paletteSize, func(j int) bool { return cumulative[j] > value })
+		coords[i] = float64(int(math.Min(float64(index), float64(paletteSize - 1))))
+	}
+}
+
+// gradientColoring spreads the palette over range of a value of cells' positions. Periodic values
+// wrap around, so the palette goes there and back, to have no seam. Other values have their range
+// taken from visible cells.
+type gradientColoring struct {
+	name     string
+	periodic bool
+	value    func(position mgl32.Vec3) float64
+}
+
+func (coloring gradientColoring) Name() string {
+	return coloring.name
+}
+
+func (gradientColoring) Parameters(paletteSize int) []DistributionParameter {
+	return []DistributionParameter{
+		{"Repeat", 1.0, 1.0, 10.0},
+		{"Blend", 1.0, 0.0, 1.0},
+	}
+}
+
+func (gradientColoring) UsesPositions() bool {
+	return true
+}
+
+func (coloring gradientColoring) Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64) {
+	values := make([]float64, len(coords))
+	low, high := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		values[i] = coloring.value(matrices[i].Col(3).Vec3())
+		if !isHiddenCell(&matrices[i]) {
+			low, high = math.Min(low, values[i]), math.Max(high, values[i])
+		}
+	}
+	repeat := parameters["Repeat"]
+	if coloring.periodic {
+		low, high, repeat = 0.0, 1.0, math.Round(repeat) * 2.0
+	}
+	for i := range coords {
+		t := 0.0
+		if high > low {
+			t = clamp((values[i] - low) / (high - low), 0, 1)
+		}
+		// The palette goes back and forth, so repeated gradients have no seams.
+		t = math.Mod(t * repeat, 2.0)
+		if t > 1.0 {
+			t = 2.0 - t
+		}
+		coords[i] = getBlendedCoord(t * float64(paletteSize - 1), parameters["Blend"])
+	}
+}
+
+// noiseColoring spreads the palette over bands of 3D value noise. Noise values are ranked, so every
+// color covers the same share of cells.
+type noiseColoring struct{}
+
+func (noiseColoring) Name() string {
+	return "noise"
+}
+
+func (noiseColoring) Parameters(paletteSize int) []DistributionParameter {
+	return []DistributionParameter{
+		{"Frequency", 0.2, 0.01, 2.0},
+		{"Octaves", 2.0, 1.0, 5.0},
+		{"Blend", 0.0, 0.0, 1.0},
+	}
+}
+
+func (noiseColoring) UsesPositions() bool {
+	return true
+}
+
+func (noiseColoring) Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64) {
+	frequency, octaves := parameters["Frequency"], int(math.Round(parameters["Octaves"]))
+	values := make([]float64, len(coords))
+	order := make([]int, len(coords))
+	for i := range values {
+		position := matrices[i].Col(3).Vec3()
+		amplitude, scale := 1.0, frequency
+		for octave := 0; octave < octaves; octave++ {
+			point := [3]float64{float64(position[0]) * scale, float64(position[1]) * scale, float64(position[2]) * scale}
+			values[i] += getValueNoise(point, seed + int64(octave)) * amplitude
+			amplitude, scale = amplitude * 0.5, scale * 2.0
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	// Every color covers an equal band of ranks, blending reaches from centers of bands.
+	for rank, i := range order {
+		band := (float64(rank) + 0.5) / float64(len(order)) * float64(paletteSize) - 0.5
+		coords[i] = clamp(getBlendedCoord(band, parameters["Blend"]), 0, float64(paletteSize - 1))
+	}
+}
+
+// getValueNoise returns smoothly interpolated noise at point in [0, 1] range. Values at integer
+// points are random, given by hash of the point and seed.
+func getValueNoise(point [3]float64, seed int64) float64 {
+	var base [3]int64
+	var t [3]float64
+	for i := range point {
+		floor := math.Floor(point[i])
+		base[i] = int64(floor)
+		t[i] = point[i] - floor
+		t[i] = t[i] * t[i] * (3.0 - 2.0 * t[i])
+	}
+	value := 0.0
+	for corner := 0; corner < 8; corner++ {
+		weight := 1.0
+		var lattice [3]int64
+		for i := range lattice {
+			if corner & (1 << uint(i)) != 0 {
+				lattice[i], weight = base[i] + 1, weight * t[i]
+			} else {
+				lattice[i], weight = base[i], weight * (1.0 - t[i])
+			}
+		}
+		value += getLatticeValue(lattice, seed) * weight
+	}
+	return value
+}
+
+// getLatticeValue returns random value of integer point in [0, 1) range.
+func getLatticeValue(point [3]int64, seed int64) float64 {
+	hash := uint64(seed) * 0x9E3779B97F4A7C15
+	for _, x := range point {
+		hash ^= uint64(x) + 0x9E3779B97F4A7C15 + (hash << 6) + (hash >> 2)
+		hash *= 0xBF58476D1CE4E5B9
+		hash ^= hash >> 31
+	}
+	return float64(hash >> 11) / float64(1 << 53)
+}
+
+// clusterColoring gives cells colors of the nearest of randomly chosen cells, so colors form patches.
+type clusterColoring struct{}
+
+func (clusterColoring) Name() string {
+	return "clusters"
+}
+
+func (clusterColoring) Parameters(paletteSize int) []DistributionParameter {
+	return []DistributionParameter{
+		{"Clusters", 12.0, 1.0, 64.0},
+	}
+}
+
+func (clusterColoring) UsesPositions() bool {
+	return true
+}
+
+func (clusterColoring) Assign(coords []float64, cells []Cell, matrices []mgl32.Mat4, seed int64, paletteSize int, parameters map[string]float64) {
+	// Centers of clusters are picked from visible cells.
+	visible := make([]int, 0, len(coords))
+	for i := range matrices[:len(coords)] {
+		if !isHiddenCell(&matrices[i]) {
+			visible = append(visible, i)
+		}
+	}
+	if len(visible) == 0 {
+		return
+	}
+	random := rand.New(rand.NewSource(seed))
+	count := int(math.Min(math.Round(parameters["Clusters"]), float64(len(visible))))
+	centers := make([]mgl32.Vec3, count)
+	colors := make([]float64, count)
+	// Clusters take turns in the palette, so every color is used when there are enough of them.
+	offset := random.Intn(paletteSize)
+	for i, j := range getRandomSample(random, len(visible), count) {
+		centers[i] = matrices[visible[j]].Col(3).Vec3()
+		colors[i] = float64((i + offset) % paletteSize)
+	}
+
+	forEachCellParallel(len(coords), func(start, end int) {
+		for i := start; i < end; i++ {
+			position := matrices[i].Col(3).Vec3()
+			nearest, nearestDistance := 0, float32(math.Inf(1))
+			for j := range centers {
+				if distance := centers[j].Sub(position).LenSqr(); distance < nearestDistance {
+					nearest, nearestDistance = j, distance
+				}
+			}
+			coords[i] = colors[nearest]
+		}
+	})
+}
+
+// getRandomSample returns count distinct random integers in [0, n) range, using Floyd's algorithm,
+// so only the chosen ones are allocated.
+func getRandomSample(random *rand.Rand, n, count int) []int {
+	sample := make([]int, 0, count)
+	chosen := make(map[int]bool, count)
+	for i := n - count; i < n; i++ {
+		j := random.Intn(i + 1)
+		if chosen[j] {
+			j = i
+		}
+		chosen[j] = true
+		sample = append(sample, j)
+	}
+	return sample
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGetRandomSample(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, count := range []int{0, 1, 10, 100} {
+		sample := getRandomSample(random, 100, count)
+		expectValue(t, "Sample size", len(sample), count)
+		seen := make(map[int]bool)
+		for _, value := range sample {
+			if value < 0 || value >= 100 || seen[value] {
+				t.Fatalf("sample %v isn't made of distinct values in range", sample)
+			}
+			seen[value] = true
+		}
+	}
+}
+
+func TestPositionColorsIgnoreAnimation(t *testing.T) {
+	settings := CopyCellSettings(defaultCellSettings)
+	settings.Count = 500
+	settings.Animation.Orbit = AnimationMotion{0.5, 0.5}
+	settings.Animation.Wave = AnimationMotion{0.3, 2.0}
+	defer SetAnimationTime(GetAnimationTime())
+
+	for _, name := range []string{"radius", "azimuth", "noise", "clusters"} {
+		settings.Coloring = ColoringSettings{name, nil}
+		layer := GetCellLayer(settings, settings.Count)
+		SetAnimationTime(0.0)
+		_, colors := layer.GetInstances(&settings)
+		expected := GetLayerColors(layer.Cells, &settings)
+		// Marked color stays unless colors are computed again.
+		colors[0][3] = -1.0
+		SetAnimationTime(3.7)
+		_, colors = layer.GetInstances(&settings)
+		if colors[0][3] != -1.0 {
+			t.Errorf("colors of %s coloring are computed again as cells move", name)
+		}
+		colors[0] = expected[0]
+		if !isSamePalette(colors, expected) {
+			t.Errorf("colors of %s coloring differ from colors of exported layer", name)
+		}
+	}
+}
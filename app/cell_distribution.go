@@ -0,0 +1,279 @@
+package app
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Golden angle in radians, used by spiral distributions.
+var goldenAngle = math.Pi * (3.0 - math.Sqrt(5.0))
+
+// DistributionSettings select distribution of cells and its parameters.
+// Parameters missing from the map have their default values.
+type DistributionSettings struct {
+	Name       string
+	Parameters map[string]float64
+}
+
+// DistributionParameter describes a parameter of cell distribution, as shown in UI.
+type DistributionParameter struct {
+	Name     string
+	Default  float64
+	Min, Max float64
+}
+
+// CellDistribution places cells in the scene. Every cell has random (but fixed) normalized
+// coordinates, distribution maps them into world space between RadiusMin and RadiusMax.
+// Distributions can also place cells by their index, e.g. along a spiral.
+type CellDistribution interface {
+	Name() string
+	Parameters() []DistributionParameter
+	// Place returns position of cell at index (out of count placed cells)
+	// and point in world space the cell faces.
+	Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3)
+}
+
+// Name of the distribution used when none is specified, it's the original look of iris.
+const DefaultDistributionName = "iris"
+
+var cellDistributions = []CellDistribution{
+	irisDistribution{},
+	sphereShellDistribution{},
+	goldenSpiralDistribution{},
+	torusDistribution{},
+	helixDistribution{},
+	concentricRingsDistribution{},
+	fibonacciDiskDistribution{},
+}
+
+// GetCellDistribution returns distribution with name, or the default distribution
+// if there's no distribution with such name.
+func GetCellDistribution(name string) CellDistribution {
+	for _, distribution := range cellDistributions {
+		if distribution.Name() == name {
+			return distribution
+		}
+	}
+	return cellDistributions[0]
+}
+
+// GetNextCellDistribution returns name of distribution following the one with name,
+// wrapping around after the last one.
+func GetNextCellDistribution(name string) string {
+	for i, distribution := range cellDistributions {
+		if distribution.Name() == name {
+			return cellDistributions[(i + 1) % len(cellDistributions)].Name()
+		}
+	}
+	return cellDistributions[0].Name()
+}
+
+// GetDistributionParameters returns values of all the parameters of distribution selected
+// in settings. Missing values are filled with defaults, all the values are clamped to their range.
+func GetDistributionParameters(settings *DistributionSettings) map[string]float64 {
+	distribution := GetCellDistribution(settings.Name)
+	parameters := make(map[string]float64)
+	for _, parameter := range distribution.Parameters() {
+		value, ok := settings.Parameters[parameter.Name]
+		if !ok || math.IsNaN(value) {
+			value = parameter.Default
+		}
+		parameters[parameter.Name] = clamp(value, parameter.Min, parameter.Max)
+	}
+	return parameters
+}
+
+// copyDistributionSettings returns deep copy of settings.
+func copyDistributionSettings(settings DistributionSettings) DistributionSettings {
+	parameters := make(map[string]float64, len(settings.Parameters))
+	for name, value := range settings.Parameters {
+		parameters[name] = value
+	}
+	settings.Parameters = parameters
+	return settings
+}
+
+// getSortedParameterNames returns names of parameters in the map, sorted.
+func getSortedParameterNames(parameters map[string]float64) []string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// uniformPolar maps cell's normally distributed polar coordinate to uniform [0, 1] range.
+func (cell *Cell) uniformPolar() float64 {
+	return 0.5 * (1.0 + math.Erf(cell.polar / math.Sqrt2))
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b - a) * t
+}
+
+// irisDistribution places cells around normally distributed polar angle, with uniform azimuth and radius.
+// Its parameters are PolarStd and PolarMean of CellSettings.
+type irisDistribution struct{}
+
+func (irisDistribution) Name() string {
+	return DefaultDistributionName
+}
+
+func (irisDistribution) Parameters() []DistributionParameter {
+	return nil
+}
+
+func (irisDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	polar := cell.polar * settings.PolarStd + settings.PolarMean
+	radius := lerp(settings.RadiusMin, settings.RadiusMax, cell.radius)
+	return vecFromPolarCoords(cell.azimuth, polar, radius), mgl32.Vec3{}
+}
+
+// sphereShellDistribution places cells uniformly in a spherical shell.
+type sphereShellDistribution struct{}
+
+func (sphereShellDistribution) Name() string {
+	return "sphere"
+}
+
+func (sphereShellDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Coverage", 1.0, 0.05, 1.0},
+	}
+}
+
+func (sphereShellDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	// Uniform distribution on a sphere (cap) has uniformly distributed cosine of polar angle.
+	maxPolar := parameters["Coverage"] * math.Pi
+	polar := math.Acos(lerp(1.0, math.Cos(maxPolar), cell.uniformPolar()))
+	radius := lerp(settings.RadiusMin, settings.RadiusMax, cell.radius)
+	return vecFromPolarCoords(cell.azimuth, polar, radius), mgl32.Vec3{}
+}
+
+// goldenSpiralDistribution places cells along a spiral going around sphere from pole to pole.
+type goldenSpiralDistribution struct{}
+
+func (goldenSpiralDistribution) Name() string {
+	return "spiral"
+}
+
+func (goldenSpiralDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Angle", goldenAngle, 0.0, math.Pi},
+		{"Jitter", 0.0, 0.0, 1.0},
+	}
+}
+
+func (goldenSpiralDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	polar := math.Acos(1.0 - 2.0 * (float64(index) + 0.5) / float64(count))
+	azimuth := float64(index) * parameters["Angle"]
+	radius := lerp(settings.RadiusMax, settings.RadiusMin, cell.radius * parameters["Jitter"])
+	return vecFromPolarCoords(azimuth, polar, radius), mgl32.Vec3{}
+}
+
+// torusDistribution places cells in a torus lying in horizontal plane.
+type torusDistribution struct{}
+
+func (torusDistribution) Name() string {
+	return "torus"
+}
+
+func (torusDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Thickness", 1.0, 0.05, 2.0},
+		{"Fill", 0.0, 0.0, 1.0},
+	}
+}
+
+func (torusDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	majorRadius := (settings.RadiusMin + settings.RadiusMax) / 2.0
+	minorRadius := (settings.RadiusMax - settings.RadiusMin) / 2.0 * parameters["Thickness"]
+	tubeAngle := cell.uniformPolar() * math.Pi * 2.0
+	tubeRadius := minorRadius * (1.0 - parameters["Fill"] * cell.radius)
+
+	center := vecFromPolarCoords(cell.azimuth, math.Pi / 2.0, majorRadius)
+	outwards := vecFromPolarCoords(cell.azimuth, math.Pi / 2.0, 1.0)
+	offset := outwards.Mul(float32(math.Cos(tubeAngle) * tubeRadius)).Add(mgl32.Vec3{0, float32(math.Sin(tubeAngle) * tubeRadius), 0})
+	return center.Add(offset), center
+}
+
+// helixDistribution places cells along strands of a vertical helix.
+type helixDistribution struct{}
+
+func (helixDistribution) Name() string {
+	return "helix"
+}
+
+func (helixDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Turns", 3.0, 0.5, 10.0},
+		{"Height", 20.0, 1.0, 60.0},
+		{"Strands", 2.0, 1.0, 6.0},
+		{"Spread", 0.5, 0.0, 5.0},
+	}
+}
+
+func (helixDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	strands := math.Round(parameters["Strands"])
+	strand := math.Floor(cell.azimuth / (math.Pi * 2.0) * strands)
+	t := cell.radius
+	angle := t * parameters["Turns"] * math.Pi * 2.0 + strand / strands * math.Pi * 2.0
+	height := (t - 0.5) * parameters["Height"]
+	radius := settings.RadiusMax + cell.polar * parameters["Spread"]
+
+	position := vecFromPolarCoords(angle, math.Pi / 2.0, radius)
+	position[1] = float32(height)
+	return position, mgl32.Vec3{0, float32(height), 0}
+}
+
+// concentricRingsDistribution places cells onto rings evenly spaced between RadiusMin and RadiusMax.
+type concentricRingsDistribution struct{}
+
+func (concentricRingsDistribution) Name() string {
+	return "rings"
+}
+
+func (concentricRingsDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Rings", 5.0, 1.0, 20.0},
+		{"Spread", 0.2, 0.0, 2.0},
+	}
+}
+
+func (concentricRingsDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	rings := math.Round(parameters["Rings"])
+	ring := math.Min(math.Floor(cell.radius * rings), rings - 1)
+	t := 0.0
+	if rings > 1 {
+		t = ring / (rings - 1)
+	}
+	radius := lerp(settings.RadiusMin, settings.RadiusMax, t)
+	position := vecFromPolarCoords(cell.azimuth, math.Pi / 2.0, radius)
+	position[1] = float32(cell.polar * parameters["Spread"])
+	return position, mgl32.Vec3{}
+}
+
+// fibonacciDiskDistribution places cells evenly on a disk in golden angle pattern, like seeds of a sunflower.
+type fibonacciDiskDistribution struct{}
+
+func (fibonacciDiskDistribution) Name() string {
+	return "disk"
+}
+
+func (fibonacciDiskDistribution) Parameters() []DistributionParameter {
+	return []DistributionParameter{
+		{"Thickness", 0.0, 0.0, 2.0},
+	}
+}
+
+func (fibonacciDiskDistribution) Place(cell *Cell, index, count int, settings *CellSettings, parameters map[string]float64) (mgl32.Vec3, mgl32.Vec3) {
+	// Square root keeps area per cell constant.
+	t := math.Sqrt((float64(index) + 0.5) / float64(count))
+	radius := lerp(settings.RadiusMin, settings.RadiusMax, t)
+	position := vecFromPolarCoords(float64(index) * goldenAngle, math.Pi / 2.0, radius)
+	position[1] = float32(cell.polar * parameters["Thickness"])
+	return position, mgl32.Vec3{}
+}
@@ -0,0 +1,399 @@
+package app
+
+import (
+	"reflect"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"../lib/graphics"
+)
+
+// Speeds of transitions between layer settings.
+const layerColorUpdateSpeed = 5.0
+const layerPaletteUpdateSpeed = 3.0
+const layerShapeUpdateSpeed = 15.0
+
+// Portion of white mixed into color of highlighted cell.
+const cellHighlightAmount = 0.5
+
+// CellLayer holds runtime state of a single layer of cells - its cells and parameters
+// transitioning smoothly to the layer's settings.
+type CellLayer struct {
+	// Pool of cells, it grows when more cells are shown, up to maxCount cells.
+	Cells        []Cell
+	maxCount     int
+	ColorsParams []ColorParameter
+	PickerStates []bool
+
+	RadiusMin, RadiusMax FloatParameter
+	Count                FloatParameter
+
+	seed int64
+	// Palette which is being faded out after palette's size has changed.
+	previousPalette   []mgl32.Vec4
+	paletteTransition FloatParameter
+
+	// Instance data of cells, rebuilt only when settings it was computed from change.
+	matrices         []mgl32.Mat4
+	colors           []mgl32.Vec4
+	matricesSettings CellSettings
+	matricesValid    bool
+	matricesTime     float64
+	colorsPalette    []mgl32.Vec4
+	colorsTransition float64
+	colorsPoints     PointsSettings
+	colorsOverrides  []CellOverride
+	colorsSymmetry   SymmetrySettings
+	colorsColoring   ColoringSettings
+	colorsHighlight  int
+	colorsPlacement  CellSettings
+	colorsValid      bool
+	// Model matrices of animated cells as they're placed without animation, colors given by positions
+	// of cells are assigned by them.
+	placement []mgl32.Mat4
+	// Scales keeping cells apart, computed for settings the layer is transitioning to.
+	separation         []float32
+	separationSettings CellSettings
+	separationValid    bool
+
+	// Index of highlighted cell, -1 if there's none. Its color without highlight is kept.
+	highlight      int
+	highlightColor mgl32.Vec4
+
+	// Compact instance data of cells uploaded to GPU, used if transforms are computed there.
+	gpuInstances        graphics.InstanceBuffer
+	gpuInstancesCreated bool
+	gpuInstancesValid   bool
+}
+
+// GetCellLayer returns layer with up to maxCount cells, showing settings.
+func GetCellLayer(settings CellSettings, maxCount int) CellLayer {
+	if maxCount < 1 {
+		maxCount = 1
+	}
+	layer := CellLayer{
+		Cells:             make([]Cell, clampCount(settings.Count, maxCount)),
+		maxCount:          maxCount,
+		RadiusMin:         FloatParameter{settings.RadiusMin, settings.RadiusMin},
+		RadiusMax:         FloatParameter{settings.RadiusMax, settings.RadiusMax},
+		Count:             FloatParameter{float64(settings.Count), float64(settings.Count)},
+		seed:              settings.Seed,
+		paletteTransition: FloatParameter{1.0, 1.0},
+		highlight:         -1,
+	}
+	GenerateCells(layer.Cells, layer.seed)
+	for _, color := range settings.Colors {
+		layer.ColorsParams = append(layer.ColorsParams, ColorParameter{color, color})
+	}
+	layer.PickerStates = make([]bool, len(settings.Colors))
+	return layer
+}
+
+// SetTarget makes the layer transition from its current settings to target settings.
+// Current settings are updated during the transition.
+func (layer *CellLayer) SetTarget(current *CellSettings, target CellSettings) {
+	layer.SetSeed(current, target.Seed)
+
+	// Currently shown values are kept, they transition to the target ones.
+	colors := current.Colors
+	radiusMin, radiusMax, count := current.RadiusMin, current.RadiusMax, current.Count
+	*current = CopyCellSettings(target)
+	current.Colors = colors
+	current.RadiusMin, current.RadiusMax, current.Count = radiusMin, radiusMax, count
+
+	layer.SetPalette(current, target.Colors)
+	layer.RadiusMin.Target = target.RadiusMin
+	layer.RadiusMax.Target = target.RadiusMax
+	layer.Count.Target = float64(target.Count)
+}
+
+// SetSeed regenerates cells of the layer, if seed has changed.
+func (layer *CellLayer) SetSeed(current *CellSettings, seed int64) {
+	current.Seed = seed
+	if seed != layer.seed {
+		layer.seed = seed
+		GenerateCells(layer.Cells, seed)
+		layer.matricesValid, layer.colorsValid, layer.gpuInstancesValid = false, false, false
+	}
+}
+
+// SetPalette starts transition of cells' colors to palette colors. Palettes of the same size
+// transition color by color, otherwise the whole palette fades into the new one.
+func (layer *CellLayer) SetPalette(current *CellSettings, colors []mgl32.Vec4) {
+	if len(colors) == len(layer.ColorsParams) {
+		for i := range layer.ColorsParams {
+			layer.ColorsParams[i].Target = colors[i]
+		}
+		return
+	}
+
+	layer.previousPalette = append(layer.previousPalette[:0], current.Colors...)
+	layer.paletteTransition = FloatParameter{0.0, 1.0}
+	layer.colorsValid = false
+	current.Colors = make([]mgl32.Vec4, len(colors))
+	copy(current.Colors, colors)
+	layer.ColorsParams = layer.ColorsParams[:0]
+	for _, color := range colors {
+		layer.ColorsParams = append(layer.ColorsParams, ColorParameter{color, color})
+	}
+	for len(layer.PickerStates) < len(colors) {
+		layer.PickerStates = append(layer.PickerStates, false)
+	}
+	layer.PickerStates = layer.PickerStates[:len(colors)]
+}
+
+// Update advances transitions of the layer and writes their current values into current settings.
+func (layer *CellLayer) Update(dt float64, current *CellSettings) {
+	for i := range current.Colors {
+		layer.ColorsParams[i].Update(dt, layerColorUpdateSpeed)
+		current.Colors[i] = layer.ColorsParams[i].Val
+	}
+	layer.paletteTransition.Update(dt, layerPaletteUpdateSpeed)
+
+	layer.RadiusMin.Update(dt, layerShapeUpdateSpeed)
+	layer.RadiusMax.Update(dt, layerShapeUpdateSpeed)
+	layer.Count.Update(dt, layerShapeUpdateSpeed)
+	current.RadiusMin = layer.RadiusMin.Val
+	current.RadiusMax = layer.RadiusMax.Val
+	current.Count = layer.GetCount()
+}
+
+// GetCount returns current number of cells in the layer.
+func (layer *CellLayer) GetCount() int {
+	return clampCount(int(layer.Count.Val), layer.maxCount)
+}
+
+func clampCount(count, maxCount int) int {
+	if count > maxCount {
+		return maxCount
+	} else if count <= 0 {
+		return 1
+	}
+	return count
+}
+
+// GetLayerCount returns number of cells shown by layer with settings. Count is clamped to maxCount
+// and, for layers placed by point cloud, to the number of its points.
+func GetLayerCount(settings *CellSettings, maxCount int) int {
+	count := clampCount(settings.Count, maxCount)
+	if points, _ := getCellPoints(&settings.Points); points != nil && count > len(points) {
+		count = len(points)
+	}
+	return count
+}
+
+// reserveCells makes sure the layer has cells for current settings, clamping their count if there can't
+// be so many cells. Cells are generated again from the same seed, so the existing ones don't change.
+func (layer *CellLayer) reserveCells(current *CellSettings) {
+	current.Count = GetLayerCount(current, layer.maxCount)
+	if current.Count <= len(layer.Cells) {
+		return
+	}
+	// Pool grows in big steps, so growing count doesn't generate cells every frame.
+	size := len(layer.Cells) * 2
+	if size < current.Count {
+		size = current.Count
+	} else if size > layer.maxCount {
+		size = layer.maxCount
+	}
+	layer.Cells = make([]Cell, size)
+	GenerateCells(layer.Cells, layer.seed)
+	layer.matricesValid, layer.colorsValid, layer.gpuInstancesValid = false, false, false
+}
+
+// GetTarget returns settings the layer is transitioning to.
+func (layer *CellLayer) GetTarget(current CellSettings) CellSettings {
+	target := CopyCellSettings(current)
+	target.RadiusMin = layer.RadiusMin.Target
+	target.RadiusMax = layer.RadiusMax.Target
+	target.Count = int(layer.Count.Target)
+	target.Colors = make([]mgl32.Vec4, len(layer.ColorsParams))
+	for i := range layer.ColorsParams {
+		target.Colors[i] = layer.ColorsParams[i].Target
+	}
+	return target
+}
+
+// Draw sets the layer's cells to be drawn in scene next frame. If gpuTransforms is set and the layer's
+// distribution supports it, transforms are computed on GPU and cells' data is uploaded only after they're
+// generated. Otherwise instance data from GetInstances is used, e.g. when a cell is highlighted.
+// Cells are drawn with mesh of the layer's shape.
+func (layer *CellLayer) Draw(current *CellSettings, gpuTransforms bool) {
+	layer.reserveCells(current)
+	mesh := GetCellShapeMesh(&current.Shape)
+	if !gpuTransforms || !SupportsGPUTransforms(current) || layer.highlight >= 0 {
+		matrices, colors := layer.GetInstances(current)
+		DrawMeshInstanced(mesh, matrices, colors, current.Count, current.Material)
+		return
+	}
+
+	if !layer.gpuInstancesCreated {
+		layer.gpuInstances = graphics.GetInstanceBuffer(cellInstanceSize)
+		layer.gpuInstancesCreated = true
+	}
+	if !layer.gpuInstancesValid {
+		graphics.UpdateInstanceBuffer(&layer.gpuInstances, len(layer.Cells), GetCellInstanceData(layer.Cells))
+		layer.gpuInstancesValid = true
+	}
+	DrawCells(mesh, layer.gpuInstances, current, layer.previousPalette, float32(layer.paletteTransition.Val))
+}
+
+// GetInstances returns model matrices and colors of the layer's cells. They're cached,
+// so they're only computed again when current settings differ from the ones they were computed from,
+// or when animation clock moves animated cells. Returned slices are reused, they're valid until the next call.
+func (layer *CellLayer) GetInstances(current *CellSettings) ([]mgl32.Mat4, []mgl32.Vec4) {
+	layer.reserveCells(current)
+	count := current.Count
+	if !layer.matricesValid || !isSamePlacement(&layer.matricesSettings, current) ||
+		(IsAnimated(&current.Animation) && layer.matricesTime != animationTime) {
+		if cap(layer.matrices) < count {
+			layer.matrices = make([]mgl32.Mat4, count)
+		}
+		separation := layer.getSeparation(current)
+		layer.matrices = layer.matrices[:count]
+		placeCellMatrices(layer.matrices, layer.Cells, current)
+		applyCellScales(layer.matrices, separation)
+		layer.matricesSettings = CopyCellSettings(*current)
+		layer.matricesTime = animationTime
+		layer.matricesValid = true
+	}
+
+	// Colors given by positions of cells change only when cells are placed differently, not when they move.
+	usesPositions := GetCellColoring(current.Coloring.Name).UsesPositions()
+	unanimated := *current
+	unanimated.Animation = AnimationSettings{}
+	if !layer.colorsValid || len(layer.colors) != count || !isSamePalette(layer.colorsPalette, current.Colors) ||
+		layer.colorsTransition != layer.paletteTransition.Val || layer.colorsPoints != current.Points ||
+		layer.colorsSymmetry != current.Symmetry || !isSameColoring(&layer.colorsColoring, &current.Coloring) ||
+		!isSameOverrideColors(layer.colorsOverrides, current.Overrides) || layer.colorsHighlight != layer.highlight ||
+		(usesPositions && !isSamePlacement(&layer.colorsPlacement, &unanimated)) {
+		if cap(layer.colors) < count {
+			layer.colors = make([]mgl32.Vec4, count)
+		}
+		layer.colors = layer.colors[:count]
+		var matrices []mgl32.Mat4
+		if usesPositions {
+			matrices = layer.getUnanimatedMatrices(current)
+		}
+		if points, file := getCellPoints(&current.Points); points != nil {
+			fillPointColors(layer.colors, points, file, current.Colors)
+		} else if cells := getSymmetricCells(layer.Cells, count, &current.Symmetry); layer.paletteTransition.Val < 1.0 {
+			fillCellColorsTransition(layer.colors, cells, matrices, current, layer.previousPalette, current.Colors,
+				float32(layer.paletteTransition.Val))
+		} else {
+			fillCellColors(layer.colors, cells, matrices, current, current.Colors)
+		}
+		applyCellOverrideColors(layer.colors, current)
+		if layer.highlight >= 0 && layer.highlight < count {
+			color := layer.colors[layer.highlight]
+			layer.highlightColor = color
+			white := mgl32.Vec4{1.0, 1.0, 1.0, color[3]}
+			layer.colors[layer.highlight] = color.Add(white.Sub(color).Mul(cellHighlightAmount))
+		}
+		layer.colorsPalette = append(layer.colorsPalette[:0], current.Colors...)
+		layer.colorsTransition = layer.paletteTransition.Val
+		layer.colorsPoints = current.Points
+		layer.colorsOverrides = copyCellOverrides(current.Overrides)
+		layer.colorsSymmetry = current.Symmetry
+		layer.colorsColoring = copyColoringSettings(current.Coloring)
+		layer.colorsHighlight = layer.highlight
+		layer.colorsPlacement = CopyCellSettings(unanimated)
+		layer.colorsValid = true
+	}
+	return layer.matrices, layer.colors
+}
+
+// getUnanimatedMatrices returns model matrices of the layer's cells as they're placed without animation.
+// They're the current matrices, unless cells are animated.
+func (layer *CellLayer) getUnanimatedMatrices(current *CellSettings) []mgl32.Mat4 {
+	if !IsAnimated(&current.Animation) {
+		return layer.matrices
+	}
+	unanimated := *current
+	unanimated.Animation = AnimationSettings{}
+	if cap(layer.placement) < current.Count {
+		layer.placement = make([]mgl32.Mat4, current.Count)
+	}
+	layer.placement = layer.placement[:current.Count]
+	placeCellMatrices(layer.placement, layer.Cells, &unanimated)
+	applyCellScales(layer.placement, layer.getSeparation(current))
+	return layer.placement
+}
+
+// getSeparation returns scales keeping cells of the layer apart, or nil if they aren't kept apart. They're
+// computed once for the settings the layer is transitioning to, so cells aren't separated again every frame
+// while they move, and only applied to the current ones.
+func (layer *CellLayer) getSeparation(current *CellSettings) []float32 {
+	if !current.Overlap.Avoid {
+		return nil
+	}
+	target := layer.GetTarget(*current)
+	target.Animation = AnimationSettings{}
+	layer.reserveCells(&target)
+	if !layer.separationValid || !isSamePlacement(&layer.separationSettings, &target) {
+		layer.separation = getCellSeparation(layer.Cells, target.Count, &target)
+		layer.separationSettings = target
+		layer.separationValid = true
+	}
+	return layer.separation
+}
+
+// Pick returns index of the layer's cell hit by ray from origin in direction, along with distance
+// to the hit. It returns -1 if no cell is hit.
+func (layer *CellLayer) Pick(current *CellSettings, origin, direction mgl32.Vec3) (int, float32) {
+	matrices, _ := layer.GetInstances(current)
+	return pickCell(matrices, origin, direction)
+}
+
+// SetHighlight highlights cell at index, -1 removes the highlight. Layer with highlighted cell
+// is drawn with transforms computed on CPU.
+func (layer *CellLayer) SetHighlight(index int) {
+	layer.highlight = index
+}
+
+// GetCellColor returns color of cell at index as it's drawn, without highlight.
+func (layer *CellLayer) GetCellColor(current *CellSettings, index int) mgl32.Vec4 {
+	_, colors := layer.GetInstances(current)
+	if index < 0 || index >= len(colors) {
+		return mgl32.Vec4{1.0, 1.0, 1.0, 1.0}
+	}
+	if index == layer.highlight {
+		return layer.highlightColor
+	}
+	return colors[index]
+}
+
+// isSamePlacement reports whether cells end up with the same model matrices with settings a and b.
+func isSamePlacement(a, b *CellSettings) bool {
+	first, second := *a, *b
+	first.Colors, second.Colors = nil, nil
+	first.Material, second.Material = MaterialSettings{}, MaterialSettings{}
+	first.Shape, second.Shape = ShapeSettings{}, ShapeSettings{}
+	first.Coloring, second.Coloring = ColoringSettings{}, ColoringSettings{}
+	// Colors of overridden cells don't change placement.
+	first.Overrides, second.Overrides = nil, nil
+	// Missing parameters map is the same as an empty one.
+	first.Distribution, second.Distribution = DistributionSettings{}, DistributionSettings{}
+	if !reflect.DeepEqual(first, second) || !isSameOverridePlacement(a.Overrides, b.Overrides) || a.Distribution.Name != b.Distribution.Name ||
+		len(a.Distribution.Parameters) != len(b.Distribution.Parameters) {
+		return false
+	}
+	for name, value := range a.Distribution.Parameters {
+		if otherValue, ok := b.Distribution.Parameters[name]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func isSamePalette(a, b []mgl32.Vec4) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const benchmarkCellsCount = 10000
+
+func getBenchmarkLayer(b *testing.B) (CellLayer, CellSettings) {
+	settings := CopyCellSettings(defaultCellSettings)
+	settings.Count = benchmarkCellsCount
+	layer := GetCellLayer(settings, benchmarkCellsCount)
+	if matrices, _ := layer.GetInstances(&settings); len(matrices) != benchmarkCellsCount {
+		b.Fatalf("layer has %d cells, expected %d", len(matrices), benchmarkCellsCount)
+	}
+	return layer, settings
+}
+
+func BenchmarkGetInstances(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		layer, settings := getBenchmarkLayer(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			layer.GetInstances(&settings)
+		}
+	})
+	b.Run("rebuild", func(b *testing.B) {
+		layer, settings := getBenchmarkLayer(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			layer.matricesValid = false
+			layer.colorsValid = false
+			layer.GetInstances(&settings)
+		}
+	})
+}
+
+// BenchmarkCellModelMatricesPerFrame measures how instance data used to be computed every frame,
+// into newly allocated slices on a single goroutine.
+func BenchmarkCellModelMatricesPerFrame(b *testing.B) {
+	layer, settings := getBenchmarkLayer(b)
+	distribution := GetCellDistribution(settings.Distribution.Name)
+	parameters := GetDistributionParameters(&settings.Distribution)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matrices := make([]mgl32.Mat4, settings.Count)
+		for j := range matrices {
+			cell := &layer.Cells[j]
+			position, target := distribution.Place(cell, j, settings.Count, &settings, parameters)
+			scale := getCellScale(cell, &settings)
+			matrices[j] = getLookAtMatrix(position, target).Inv().Mul4(mgl32.Scale3D(scale[0], scale[1], scale[2]))
+		}
+		colors := GetCellColors(layer.Cells, settings.Colors, settings.Count)
+		if len(colors) != len(matrices) {
+			b.Fatal("colors don't match cells")
+		}
+	}
+}
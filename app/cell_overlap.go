@@ -0,0 +1,210 @@
+package app
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Overlapping cells are shrunk by this factor until they fit, at most cellOverlapSteps times.
+// Cells which don't fit even then are hidden.
+const cellOverlapShrink = 0.75
+const cellOverlapSteps = 6
+
+// Cells are spread over buckets of spatial hash at most this many times along each axis,
+// so huge cells don't fill the hash.
+const maxCellBucketsPerAxis = 8
+
+// cellBox is oriented bounding box of a cell, grown by half of the gap kept between cells.
+type cellBox struct {
+	center  mgl32.Vec3
+	axes    [3]mgl32.Vec3
+	extents mgl32.Vec3
+	// Axis aligned bounds of the box.
+	min, max mgl32.Vec3
+}
+
+// getCellBox returns box of cell with model matrix. Meshes of cells fit into the unit cube
+// centered at the origin, so the box is that cube transformed by the matrix.
+func getCellBox(matrix mgl32.Mat4, gap float32) cellBox {
+	box := cellBox{center: matrix.Col(3).Vec3()}
+	var halfSize mgl32.Vec3
+	for i := range box.axes {
+		axis := matrix.Col(i).Vec3()
+		length := axis.Len()
+		if length > 0 {
+			box.axes[i] = axis.Mul(1.0 / length)
+		}
+		box.extents[i] = length * 0.5 + gap * 0.5
+		for j := range halfSize {
+			halfSize[j] += float32(math.Abs(float64(box.axes[i][j]))) * box.extents[i]
+		}
+	}
+	box.min, box.max = box.center.Sub(halfSize), box.center.Add(halfSize)
+	return box
+}
+
+// overlaps reports whether boxes a and b intersect, using separating axis test. Boxes only
+// touching each other don't overlap.
+func (a *cellBox) overlaps(b *cellBox) bool {
+	for i := range a.min {
+		if a.max[i] <= b.min[i] || b.max[i] <= a.min[i] {
+			return false
+		}
+	}
+	// Rotation of b in coordinates of a, with a small epsilon added, so parallel edges don't cause trouble.
+	var rotation, absRotation [3][3]float32
+	for i := range a.axes {
+		for j := range b.axes {
+			rotation[i][j] = a.axes[i].Dot(b.axes[j])
+			absRotation[i][j] = float32(math.Abs(float64(rotation[i][j]))) + 1e-6
+		}
+	}
+	offset := b.center.Sub(a.center)
+	t := mgl32.Vec3{offset.Dot(a.axes[0]), offset.Dot(a.axes[1]), offset.Dot(a.axes[2])}
+
+	// Face axes of a and b.
+	for i := 0; i < 3; i++ {
+		radiusB := b.extents[0] * absRotation[i][0] + b.extents[1] * absRotation[i][1] + b.extents[2] * absRotation[i][2]
+		if abs32(t[i]) >= a.extents[i] + radiusB {
+			return false
+		}
+	}
+	for j := 0; j < 3; j++ {
+		radiusA := a.extents[0] * absRotation[0][j] + a.extents[1] * absRotation[1][j] + a.extents[2] * absRotation[2][j]
+		if abs32(t[0] * rotation[0][j] + t[1] * rotation[1][j] + t[2] * rotation[2][j]) >= radiusA + b.extents[j] {
+			return false
+		}
+	}
+	// Cross products of edges of a and b.
+	for i := 0; i < 3; i++ {
+		i1, i2 := (i + 1) % 3, (i + 2) % 3
+		for j := 0; j < 3; j++ {
+			j1, j2 := (j + 1) % 3, (j + 2) % 3
+			radiusA := a.extents[i1] * absRotation[i2][j] + a.extents[i2] * absRotation[i1][j]
+			radiusB := b.extents[j1] * absRotation[i][j2] + b.extents[j2] * absRotation[i][j1]
+			if abs32(t[i2] * rotation[i1][j] - t[i1] * rotation[i2][j]) >= radiusA + radiusB {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cellSpatialHash holds indices of placed cells in buckets of a uniform grid, every cell is
+// in all buckets its bounds touch.
+type cellSpatialHash struct {
+	bucketSize float32
+	buckets    map[[3]int32][]int32
+	// Stamps of the last query which visited cells, so cells in several buckets are tested once.
+	stamps []int
+	query  int
+}
+
+// getCellSpatialHash returns empty hash for boxes. Buckets are about as big as an average box.
+func getCellSpatialHash(boxes []cellBox) cellSpatialHash {
+	sum, largest := 0.0, 0.0
+	for i := range boxes {
+		size := boxes[i].max.Sub(boxes[i].min)
+		extent := math.Max(float64(size[0]), math.Max(float64(size[1]), float64(size[2])))
+		sum += extent
+		largest = math.Max(largest, extent)
+	}
+	bucketSize := math.Max(sum / float64(len(boxes)), largest / maxCellBucketsPerAxis)
+	return cellSpatialHash{
+		bucketSize: float32(math.Max(bucketSize, 1e-6)),
+		buckets:    make(map[[3]int32][]int32),
+		stamps:     make([]int, len(boxes)),
+	}
+}
+
+// forEachBucket calls visit with keys of all buckets touched by box.
+func (hash *cellSpatialHash) forEachBucket(box *cellBox, visit func(key [3]int32)) {
+	var first, last [3]int32
+	for i := range first {
+		first[i] = int32(math.Floor(float64(box.min[i] / hash.bucketSize)))
+		last[i] = int32(math.Floor(float64(box.max[i] / hash.bucketSize)))
+	}
+	for x := first[0]; x <= last[0]; x++ {
+		for y := first[1]; y <= last[1]; y++ {
+			for z := first[2]; z <= last[2]; z++ {
+				visit([3]int32{x, y, z})
+			}
+		}
+	}
+}
+
+func (hash *cellSpatialHash) insert(boxes []cellBox, index int) {
+	hash.forEachBucket(&boxes[index], func(key [3]int32) {
+		hash.buckets[key] = append(hash.buckets[key], int32(index))
+	})
+}
+
+// overlaps reports whether box overlaps any cell in the hash.
+func (hash *cellSpatialHash) overlaps(boxes []cellBox, box *cellBox) bool {
+	hash.query++
+	found := false
+	hash.forEachBucket(box, func(key [3]int32) {
+		if found {
+			return
+		}
+		for _, index := range hash.buckets[key] {
+			if hash.stamps[index] == hash.query {
+				continue
+			}
+			hash.stamps[index] = hash.query
+			if box.overlaps(&boxes[index]) {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+// separateCells returns scales of cells with model matrices, which keep cells at least minGap apart. Cells are
+// processed in order and every cell yields to the ones before it - it's shrunk in place until it fits, or hidden
+// (scaled to zero) if it doesn't fit at all. Cells are thus never affected by the cells after them.
+func separateCells(matrices []mgl32.Mat4, minGap float64) []float32 {
+	scales := make([]float32, len(matrices))
+	if len(matrices) == 0 {
+		return scales
+	}
+	gap := float32(math.Max(minGap, 0.0))
+	boxes := make([]cellBox, len(matrices))
+	for i := range matrices {
+		boxes[i] = getCellBox(matrices[i], gap)
+	}
+	hash := getCellSpatialHash(boxes)
+	for i := range matrices {
+		scales[i] = 1.0
+		// Hidden cells have no volume, they don't stand in the way of others.
+		if matrices[i].Mat3().Det() == 0 {
+			continue
+		}
+		for step := 0; ; step++ {
+			if !hash.overlaps(boxes, &boxes[i]) {
+				hash.insert(boxes, i)
+				break
+			}
+			if step == cellOverlapSteps {
+				scales[i] = 0.0
+				break
+			}
+			scales[i] *= cellOverlapShrink
+			boxes[i] = getCellBox(matrices[i].Mul4(mgl32.Scale3D(scales[i], scales[i], scales[i])), gap)
+		}
+	}
+	return scales
+}
+
+// applyCellScales scales model matrices of cells in place by scales, cells without scale aren't changed.
+func applyCellScales(matrices []mgl32.Mat4, scales []float32) {
+	for i := 0; i < len(matrices) && i < len(scales); i++ {
+		if scales[i] == 0.0 {
+			matrices[i] = mgl32.Mat4{}
+		} else if scales[i] != 1.0 {
+			matrices[i] = matrices[i].Mul4(mgl32.Scale3D(scales[i], scales[i], scales[i]))
+		}
+	}
+}
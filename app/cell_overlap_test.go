@@ -0,0 +1,86 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func getOverlapTestMatrices(count int) []mgl32.Mat4 {
+	settings := CopyCellSettings(defaultCellSettings)
+	settings.Count = count
+	cells := make([]Cell, count)
+	GenerateCells(cells, settings.Seed)
+	return GetCellModelMatrices(cells, settings)
+}
+
+func TestSeparateCellsKeepsMinGap(t *testing.T) {
+	const minGap = 0.2
+	matrices := getOverlapTestMatrices(2000)
+	applyCellScales(matrices, separateCells(matrices, minGap))
+
+	// Boxes grown by half of the gap (a bit less, for rounding errors) touch only if cells are closer than the gap.
+	boxes := make([]cellBox, len(matrices))
+	for i := range matrices {
+		boxes[i] = getCellBox(matrices[i], minGap * 0.999)
+	}
+	shown := 0
+	for i := range boxes {
+		if matrices[i].Mat3().Det() == 0 {
+			continue
+		}
+		shown++
+		for j := i + 1; j < len(boxes); j++ {
+			if matrices[j].Mat3().Det() != 0 && boxes[i].overlaps(&boxes[j]) {
+				t.Fatalf("cells %d and %d are closer than %v", i, j, minGap)
+			}
+		}
+	}
+	if shown == 0 {
+		t.Error("all cells are hidden")
+	}
+}
+
+func TestCellLayerSeparatesTargetOnce(t *testing.T) {
+	settings := CopyCellSettings(defaultCellSettings)
+	settings.Count = 500
+	settings.Overlap = OverlapSettings{true, 0.1}
+	settings.Animation.Orbit = AnimationMotion{0.5, 0.2}
+	layer := GetCellLayer(settings, settings.Count)
+	defer SetAnimationTime(GetAnimationTime())
+
+	SetAnimationTime(0.0)
+	layer.GetInstances(&settings)
+	separation := layer.separation
+	SetAnimationTime(1.0)
+	matrices, _ := layer.GetInstances(&settings)
+	if &layer.separation[0] != &separation[0] {
+		t.Error("cells were separated again when they moved")
+	}
+	// Cells are separated as they're placed without animation, which only moves them.
+	expected := GetCellModelMatrices(layer.Cells, settings)
+	for i := range matrices {
+		if !matrices[i].ApproxEqualThreshold(expected[i], 1e-4) {
+			t.Fatalf("cell %d has matrix %v, expected %v", i, matrices[i], expected[i])
+		}
+	}
+
+	// Cells transitioning to other settings are separated as they will be placed at the end.
+	layer.RadiusMax.Target = settings.RadiusMax * 1.5
+	layer.Update(0.01, &settings)
+	layer.GetInstances(&settings)
+	separation = layer.separation
+	layer.Update(0.01, &settings)
+	layer.GetInstances(&settings)
+	if &layer.separation[0] != &separation[0] {
+		t.Error("cells were separated again during transition")
+	}
+}
+
+func BenchmarkSeparateCells10k(b *testing.B) {
+	matrices := getOverlapTestMatrices(benchmarkCellsCount)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		separateCells(matrices, 0.1)
+	}
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CellOverride changes a single cell of a layer. Cell is identified by its index and the seed
+// of the layer, so the override doesn't end up on a different cell when cells are randomized.
+// Overrides of other seeds are kept, they apply again when the layer gets their seed back.
+type CellOverride struct {
+	Index int
+	Seed  int64
+	// Color replaces the cell's color when HasColor is set.
+	HasColor bool
+	Color    mgl32.Vec4
+	// Scale multiplies dimensions of the cell.
+	Scale  float64
+	Hidden bool
+}
+
+// UnmarshalJSON parses override of a cell. Missing scale doesn't change the cell's size.
+func (override *CellOverride) UnmarshalJSON(data []byte) error {
+	// Type without methods, so parsing doesn't end up calling this again.
+	type cellOverride CellOverride
+	parsed := cellOverride{Scale: 1.0}
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return err
+	}
+	*override = CellOverride(parsed)
+	return nil
+}
+
+// GetCellOverride returns override of cell at index of layer with settings. Cells without
+// override get one which doesn't change anything.
+func GetCellOverride(settings *CellSettings, index int) CellOverride {
+	if override := findCellOverride(settings, index); override != nil {
+		return *override
+	}
+	return CellOverride{Index: index, Seed: settings.Seed, Scale: 1.0}
+}
+
+// SetCellOverride stores override into settings, replacing the previous override of the same cell.
+// Overrides which don't change anything are removed.
+func SetCellOverride(settings *CellSettings, override CellOverride) {
+	overrides := make([]CellOverride, 0, len(settings.Overrides) + 1)
+	for _, other := range settings.Overrides {
+		if other.Index != override.Index || other.Seed != override.Seed {
+			overrides = append(overrides, other)
+		}
+	}
+	if override.HasColor || override.Hidden || override.Scale != 1.0 {
+		overrides = append(overrides, override)
+	}
+	settings.Overrides = overrides
+}
+
+func findCellOverride(settings *CellSettings, index int) *CellOverride {
+	for i := range settings.Overrides {
+		if settings.Overrides[i].Index == index && settings.Overrides[i].Seed == settings.Seed {
+			return &settings.Overrides[i]
+		}
+	}
+	return nil
+}
+
+// hasCellOverrides reports whether any override applies to cells of layer with settings.
+func hasCellOverrides(settings *CellSettings) bool {
+	for i := range settings.Overrides {
+		if settings.Overrides[i].Seed == settings.Seed {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCellOverrideMatrices scales model matrices of overridden cells. Hidden cells get
+// zero matrices, so they have no volume.
+func applyCellOverrideMatrices(matrices []mgl32.Mat4, settings *CellSettings) {
+	for _, override := range settings.Overrides {
+		if override.Seed != settings.Seed || override.Index < 0 || override.Index >= len(matrices) {
+			continue
+		}
+		if override.Hidden {
+			matrices[override.Index] = mgl32.Mat4{}
+		} else if override.Scale != 1.0 {
+			scale := float32(override.Scale)
+			matrices[override.Index] = matrices[override.Index].Mul4(mgl32.Scale3D(scale, scale, scale))
+		}
+	}
+}
+
+// applyCellOverrideColors replaces colors of overridden cells.
+func applyCellOverrideColors(colors []mgl32.Vec4, settings *CellSettings) {
+	for _, override := range settings.Overrides {
+		if override.Seed == settings.Seed && override.HasColor && override.Index >= 0 && override.Index < len(colors) {
+			colors[override.Index] = override.Color
+		}
+	}
+}
+
+// isSameOverridePlacement reports whether overrides a and b change model matrices of cells the same way.
+func isSameOverridePlacement(a, b []CellOverride) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Index != b[i].Index || a[i].Seed != b[i].Seed || a[i].Scale != b[i].Scale || a[i].Hidden != b[i].Hidden {
+			return false
+		}
+	}
+	return true
+}
+
+// isSameOverrideColors reports whether overrides a and b change colors of cells the same way.
+func isSameOverrideColors(a, b []CellOverride) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Index != b[i].Index || a[i].Seed != b[i].Seed || a[i].HasColor != b[i].HasColor || a[i].Color != b[i].Color {
+			return false
+		}
+	}
+	return true
+}
+
+func copyCellOverrides(overrides []CellOverride) []CellOverride {
+	if overrides == nil {
+		return nil
+	}
+	return append([]CellOverride{}, overrides...)
+}
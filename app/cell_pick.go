@@ -0,0 +1,64 @@
+package app
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// pickCell returns index of the nearest cell hit by ray from origin in direction, along with distance
+// to the hit. Cells are tested as boxes given by their model matrices, which meshes of cells fit into.
+// It returns -1 if no cell is hit.
+func pickCell(matrices []mgl32.Mat4, origin, direction mgl32.Vec3) (int, float32) {
+	direction = direction.Normalize()
+	picked, nearest := -1, float32(math.Inf(1))
+	for i := range matrices {
+		matrix := &matrices[i]
+		// Bounding spheres of cells reject most of them without inverting their matrices.
+		radiusSquared := (matrix.Col(0).Vec3().LenSqr() + matrix.Col(1).Vec3().LenSqr() + matrix.Col(2).Vec3().LenSqr()) * 0.25
+		if radiusSquared == 0 {
+			continue
+		}
+		offset := matrix.Col(3).Vec3().Sub(origin)
+		along := offset.Dot(direction)
+		if offset.LenSqr() - along * along > radiusSquared {
+			continue
+		}
+		radius := float32(math.Sqrt(float64(radiusSquared)))
+		if along + radius < 0 || along - radius > nearest {
+			continue
+		}
+
+		if matrix.Det() == 0 {
+			continue
+		}
+		inverse := matrix.Inv()
+		distance, hit := intersectUnitBox(inverse.Mul4x1(origin.Vec4(1.0)).Vec3(), inverse.Mul4x1(direction.Vec4(0.0)).Vec3())
+		if hit && distance < nearest {
+			picked, nearest = i, distance
+		}
+	}
+	return picked, nearest
+}
+
+// intersectUnitBox returns distance along ray from origin in direction to the cube [-0.5, 0.5]^3,
+// in multiples of direction's length. Rays starting inside the cube hit it at distance 0.
+func intersectUnitBox(origin, direction mgl32.Vec3) (float32, bool) {
+	enter, exit := math.Inf(-1), math.Inf(1)
+	for axis := range origin {
+		if direction[axis] == 0 {
+			if math.Abs(float64(origin[axis])) > 0.5 {
+				return 0, false
+			}
+			continue
+		}
+		first := (-0.5 - float64(origin[axis])) / float64(direction[axis])
+		second := (0.5 - float64(origin[axis])) / float64(direction[axis])
+		enter = math.Max(enter, math.Min(first, second))
+		exit = math.Min(exit, math.Max(first, second))
+	}
+	if enter > exit || exit < 0 {
+		return 0, false
+	}
+	return float32(math.Max(enter, 0.0)), true
+}
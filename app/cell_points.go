@@ -0,0 +1,301 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Formats cell layouts can be exported in.
+const (
+	PointsFormatCSV  = "csv"
+	PointsFormatJSON = "json"
+	PointsFormatPLY  = "ply"
+)
+
+// CellPoint is a single cell placed in world space, as stored in point-cloud files.
+type CellPoint struct {
+	// Layer of the scene the cell belongs to, starting at 1.
+	Layer        int
+	Position     mgl32.Vec3
+	Orientation  mgl32.Quat
+	Scale        mgl32.Vec3
+	PaletteIndex int
+	Color        mgl32.Vec4
+}
+
+// cellPointsFile is point cloud loaded from file, or the error loading failed with.
+type cellPointsFile struct {
+	points []CellPoint
+	// Whether the file has colors and palette indices of cells. Cells without colors get colors
+	// from palette by their palette indices, or by their order if they don't have those either.
+	hasColors         bool
+	hasPaletteIndices bool
+	// Layers of the file, sorted.
+	layers []int
+	// Points of every layer, 0 stands for all of them.
+	layerPoints map[int][]CellPoint
+	err         error
+}
+
+var cellPointsFiles = make(map[string]*cellPointsFile)
+
+// LoadCellPoints loads point-cloud file at path, so cells can be placed by it. Every file is loaded
+// just once, the result (or the error) is kept for the lifetime of the app.
+func LoadCellPoints(path string) error {
+	return loadCellPointsFile(path).err
+}
+
+func loadCellPointsFile(path string) *cellPointsFile {
+	file, ok := cellPointsFiles[path]
+	if !ok {
+		var err error
+		file, err = readCellPointsFile(path)
+		if err != nil {
+			log.Println("couldn't load cell points:", err)
+			file = &cellPointsFile{err: err}
+		}
+		cellPointsFiles[path] = file
+	}
+	return file
+}
+
+func readCellPointsFile(path string) (*cellPointsFile, error) {
+	var read func(reader io.Reader) (*cellPointsFile, error)
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case PointsFormatCSV:
+		read = readCellPointsCSV
+	case PointsFormatJSON:
+		read = readCellPointsJSON
+	case PointsFormatPLY:
+		read = readCellPointsPLY
+	default:
+		return nil, fmt.Errorf("%s: unsupported point-cloud format, only .csv, .json and .ply files are supported", filepath.Base(path))
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	file, err := read(reader)
+	if err == nil {
+		err = file.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return file, nil
+}
+
+// validate checks values of points and groups them by layers.
+func (file *cellPointsFile) validate() error {
+	if len(file.points) == 0 {
+		return errors.New("file has no cells")
+	}
+	file.layerPoints = map[int][]CellPoint{0: file.points}
+	for i := range file.points {
+		point := &file.points[i]
+		values := append(append(append(append([]float32{}, point.Position[:]...), point.Orientation.V[:]...), point.Orientation.W), point.Scale[:]...)
+		values = append(values, point.Color[:]...)
+		for _, value := range values {
+			if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+				return fmt.Errorf("cell %d has invalid values", i + 1)
+			}
+		}
+		if point.Layer < 0 || point.PaletteIndex < 0 {
+			return fmt.Errorf("cell %d has invalid layer or palette index", i + 1)
+		}
+		if point.Orientation.Len() < 1e-6 {
+			point.Orientation = mgl32.QuatIdent()
+		}
+		point.Orientation = point.Orientation.Normalize()
+		if point.Layer > 0 {
+			if _, ok := file.layerPoints[point.Layer]; !ok {
+				file.layers = append(file.layers, point.Layer)
+			}
+			file.layerPoints[point.Layer] = append(file.layerPoints[point.Layer], *point)
+		}
+	}
+	sort.Ints(file.layers)
+	return nil
+}
+
+// getCellPoints returns points cells with settings are placed by, along with their file. It returns nil
+// if cells don't use point cloud, or if it can't be loaded.
+func getCellPoints(settings *PointsSettings) ([]CellPoint, *cellPointsFile) {
+	if settings.File == "" {
+		return nil, nil
+	}
+	file := loadCellPointsFile(settings.File)
+	if file.err != nil || len(file.layerPoints[settings.Layer]) == 0 {
+		return nil, nil
+	}
+	return file.layerPoints[settings.Layer], file
+}
+
+// fillPointModelMatrices computes model matrices of the first len(matrices) points.
+func fillPointModelMatrices(matrices []mgl32.Mat4, points []CellPoint) {
+	forEachCellParallel(len(matrices), func(start, end int) {
+		for i := start; i < end; i++ {
+			point := &points[i]
+			translation := mgl32.Translate3D(point.Position[0], point.Position[1], point.Position[2])
+			scale := mgl32.Scale3D(point.Scale[0], point.Scale[1], point.Scale[2])
+			matrices[i] = translation.Mul4(point.Orientation.Mat4()).Mul4(scale)
+		}
+	})
+}
+
+// fillPointColors computes colors of the first len(colors) points of file.
+func fillPointColors(colors []mgl32.Vec4, points []CellPoint, file *cellPointsFile, colorPalette []mgl32.Vec4) {
+	for i := range colors {
+		if file.hasColors {
+			colors[i] = points[i].Color
+		} else if file.hasPaletteIndices {
+			colors[i] = colorPalette[points[i].PaletteIndex % len(colorPalette)]
+		} else {
+			colors[i] = colorPalette[i % len(colorPalette)]
+		}
+	}
+}
+
+// GetLayerColors returns colors of the first settings.Count cells of layer with settings.
+// Cells placed by point cloud get colors from it, overridden cells get their own colors and
+// symmetric copies of cells get colors of the cells.
+func GetLayerColors(cells []Cell, settings *CellSettings) []mgl32.Vec4 {
+	colors := make([]mgl32.Vec4, settings.Count)
+	if points, file := getCellPoints(&settings.Points); points != nil {
+		fillPointColors(colors, points, file, settings.Colors)
+	} else {
+		// Colors given by positions of cells don't change as they move.
+		var matrices []mgl32.Mat4
+		if GetCellColoring(settings.Coloring.Name).UsesPositions() {
+			unanimated := *settings
+			unanimated.Animation = AnimationSettings{}
+			matrices = GetCellModelMatrices(cells, unanimated)
+		}
+		fillCellColors(colors, getSymmetricCells(cells, settings.Count, &settings.Symmetry), matrices, settings, settings.Colors)
+	}
+	applyCellOverrideColors(colors, settings)
+	return colors
+}
+
+// getCellPoint returns cell with model matrix and color as a point. Matrix is decomposed into
+// translation, rotation and scale, mirroring is stored as negative scale along x axis.
+func getCellPoint(layer int, matrix mgl32.Mat4, paletteIndex int, color mgl32.Vec4) CellPoint {
+	point := CellPoint{Layer: layer, PaletteIndex: paletteIndex, Color: color}
+	point.Position = matrix.Col(3).Vec3()
+	var axes [3]mgl32.Vec3
+	for i := range axes {
+		axes[i] = matrix.Col(i).Vec3()
+		point.Scale[i] = axes[i].Len()
+		if point.Scale[i] > 0 {
+			axes[i] = axes[i].Mul(1.0 / point.Scale[i])
+		}
+	}
+	if matrix.Mat3().Det() < 0 {
+		point.Scale[0], axes[0] = -point.Scale[0], axes[0].Mul(-1.0)
+	}
+	point.Orientation = mgl32.Mat4ToQuat(mgl32.Mat3FromCols(axes[0], axes[1], axes[2]).Mat4()).Normalize()
+	return point
+}
+
+// getLayerPoints returns cells of layer with settings as points.
+func getLayerPoints(layer int, settings CellSettings, maxCount int) []CellPoint {
+	settings.Count = GetLayerCount(&settings, maxCount)
+	cells := make([]Cell, settings.Count)
+	GenerateCells(cells, settings.Seed)
+	matrices := GetCellModelMatrices(cells, settings)
+	colors := GetLayerColors(cells, &settings)
+	sourcePoints, file := getCellPoints(&settings.Points)
+	cells = getSymmetricCells(cells, settings.Count, &settings.Symmetry)
+
+	points := make([]CellPoint, settings.Count)
+	for i := range points {
+		paletteIndex := cells[i].colorIndex % len(settings.Colors)
+		if sourcePoints != nil {
+			paletteIndex = i % len(settings.Colors)
+			if file.hasPaletteIndices {
+				paletteIndex = sourcePoints[i].PaletteIndex % len(settings.Colors)
+			}
+		}
+		points[i] = getCellPoint(layer, matrices[i], paletteIndex, colors[i])
+	}
+	return points
+}
+
+// ExportCellPoints writes every cell of all layers of settings into EXPORTS_DIR in format,
+// counts of cells are clamped to maxCount. File is named after the preset, its path is returned.
+//
+// Every cell has its layer (starting at 1), world position, orientation as a quaternion, scale,
+// index to palette and final color. CSV files have columns named like properties of PLY vertices:
+// layer, x, y, z, qx, qy, qz, qw, sx, sy, sz, palette_index, red, green, blue and alpha.
+// JSON files have Version and list of Cells, fields of cells are named like fields of CellPoint.
+// Importing the file places cells where they were, up to float precision. Only positions are required.
+func ExportCellPoints(settings AppSettings, format string, maxCount int) (string, error) {
+	var write func(writer io.Writer, points []CellPoint) error
+	switch format {
+	case PointsFormatCSV:
+		write = writeCellPointsCSV
+	case PointsFormatJSON:
+		write = writeCellPointsJSON
+	case PointsFormatPLY:
+		write = writeCellPointsPLY
+	default:
+		return "", fmt.Errorf("unknown point-cloud format %q, supported formats are %s, %s and %s",
+			format, PointsFormatCSV, PointsFormatJSON, PointsFormatPLY)
+	}
+	var points []CellPoint
+	for i, layer := range settings.Layers {
+		points = append(points, getLayerPoints(i + 1, layer, maxCount)...)
+	}
+
+	os.MkdirAll(EXPORTS_DIR, 0700)
+	path := getUniquePath(EXPORTS_DIR, getBundleFileName(settings.Metadata.Name) + "_cells", "." + format)
+	err := writeSceneFile(path, func(writer io.Writer) error {
+		return write(writer, points)
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ImportCellPoints returns settings with cells placed by point-cloud file at path. File with cells of
+// a single layer is used by layer at index, file with more layers replaces all the layers by its own.
+// Other settings of new layers are taken from the existing ones.
+func ImportCellPoints(settings AppSettings, index int, path string, maxCount int) (AppSettings, error) {
+	file := loadCellPointsFile(path)
+	if file.err != nil {
+		return settings, file.err
+	}
+	layers := make([]CellSettings, len(settings.Layers))
+	for i := range settings.Layers {
+		layers[i] = CopyCellSettings(settings.Layers[i])
+	}
+	if len(file.layers) <= 1 {
+		layers[index].Points = PointsSettings{path, 0}
+		layers[index].Count = clampCount(len(file.points), maxCount)
+		settings.Layers = layers
+		return settings, nil
+	}
+
+	if len(file.layers) > MaxLayers {
+		return settings, fmt.Errorf("%s: file has %d layers, at most %d are supported", filepath.Base(path), len(file.layers), MaxLayers)
+	}
+	settings.Layers = make([]CellSettings, len(file.layers))
+	for i, layer := range file.layers {
+		settings.Layers[i] = CopyCellSettings(layers[int(math.Min(float64(i), float64(len(layers) - 1)))])
+		settings.Layers[i].Points = PointsSettings{path, layer}
+		settings.Layers[i].Count = clampCount(len(file.layerPoints[layer]), maxCount)
+	}
+	return settings, nil
+}
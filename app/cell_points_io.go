@@ -0,0 +1,293 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"../lib/geometry"
+)
+
+// Version of JSON point-cloud files, it's increased when their format changes.
+const cellPointsVersion = 1
+
+// Names of values of points, they're used by CSV columns and properties of PLY vertices.
+// Layer and palette index are integers, the rest are floats.
+var cellPointColumns = []string{
+	"layer", "x", "y", "z", "qx", "qy", "qz", "qw", "sx", "sy", "sz", "palette_index", "red", "green", "blue", "alpha",
+}
+
+// getCellPointValues returns values of point in the order of cellPointColumns.
+func getCellPointValues(point *CellPoint) []float32 {
+	return []float32{
+		float32(point.Layer),
+		point.Position[0], point.Position[1], point.Position[2],
+		point.Orientation.V[0], point.Orientation.V[1], point.Orientation.V[2], point.Orientation.W,
+		point.Scale[0], point.Scale[1], point.Scale[2],
+		float32(point.PaletteIndex),
+		point.Color[0], point.Color[1], point.Color[2], point.Color[3],
+	}
+}
+
+// getCellPointsFile returns file with count points read from columns by their names. Only positions
+// are required, points default to identity orientation, unit scale and opaque colors. Colors are
+// multiplied by colorScale.
+func getCellPointsFile(columns map[string][]float64, count int, colorScale float64) (*cellPointsFile, error) {
+	for _, name := range []string{"x", "y", "z"} {
+		if columns[name] == nil {
+			return nil, errors.New("cells need x, y and z values")
+		}
+	}
+	file := &cellPointsFile{
+		points:            make([]CellPoint, count),
+		hasColors:         columns["red"] != nil && columns["green"] != nil && columns["blue"] != nil,
+		hasPaletteIndices: columns["palette_index"] != nil,
+	}
+	getValue := func(name string, i int, defaultValue float64) float64 {
+		if values := columns[name]; values != nil {
+			return values[i]
+		}
+		return defaultValue
+	}
+	for i := range file.points {
+		layer, paletteIndex := getValue("layer", i, 0.0), getValue("palette_index", i, 0.0)
+		if layer != math.Trunc(layer) || paletteIndex != math.Trunc(paletteIndex) || layer > math.MaxInt32 || paletteIndex > math.MaxInt32 {
+			return nil, fmt.Errorf("cell %d has invalid layer or palette index", i + 1)
+		}
+		file.points[i] = CellPoint{
+			Layer:        int(layer),
+			Position:     mgl32.Vec3{float32(columns["x"][i]), float32(columns["y"][i]), float32(columns["z"][i])},
+			Orientation:  mgl32.Quat{
+				W: float32(getValue("qw", i, 1.0)),
+				V: mgl32.Vec3{float32(getValue("qx", i, 0.0)), float32(getValue("qy", i, 0.0)), float32(getValue("qz", i, 0.0))},
+			},
+			Scale:        mgl32.Vec3{float32(getValue("sx", i, 1.0)), float32(getValue("sy", i, 1.0)), float32(getValue("sz", i, 1.0))},
+			PaletteIndex: int(paletteIndex),
+		}
+		if file.hasColors {
+			alpha := 1.0
+			if columns["alpha"] != nil {
+				alpha = columns["alpha"][i] * colorScale
+			}
+			file.points[i].Color = mgl32.Vec4{
+				float32(columns["red"][i] * colorScale),
+				float32(columns["green"][i] * colorScale),
+				float32(columns["blue"][i] * colorScale),
+				float32(alpha),
+			}
+		}
+	}
+	return file, nil
+}
+
+// writeCellPointsCSV writes points as CSV with a header. Floats are written with just enough
+// digits to be read back exactly.
+func writeCellPointsCSV(writer io.Writer, points []CellPoint) error {
+	fmt.Fprintln(writer, strings.Join(cellPointColumns, ","))
+	line := make([]byte, 0, 256)
+	for i := range points {
+		line = line[:0]
+		for j, value := range getCellPointValues(&points[i]) {
+			if j > 0 {
+				line = append(line, ',')
+			}
+			line = strconv.AppendFloat(line, float64(value), 'g', -1, 32)
+		}
+		line = append(line, '\n')
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCellPointsCSV reads points from CSV with a header naming its columns, see cellPointColumns.
+// Unknown columns are skipped.
+func readCellPointsCSV(reader io.Reader) (*cellPointsFile, error) {
+	records := csv.NewReader(bufio.NewReader(reader))
+	records.TrimLeadingSpace = true
+	records.ReuseRecord = true
+	header, err := records.Read()
+	if err != nil {
+		return nil, errors.New("CSV file has no header")
+	}
+	names := make([]string, len(header))
+	for i, name := range header {
+		names[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+
+	columns := make(map[string][]float64)
+	count := 0
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, field := range record {
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				line, _ := records.FieldPos(i)
+				return nil, fmt.Errorf("line %d: invalid value %q", line, field)
+			}
+			columns[names[i]] = append(columns[names[i]], value)
+		}
+		count++
+	}
+	return getCellPointsFile(columns, count, 1.0)
+}
+
+// cellPointsJSON is the content of JSON point-cloud files.
+type cellPointsJSON struct {
+	Version int
+	Cells   []cellPointJSON
+}
+
+// cellPointJSON is a point in JSON file. Orientation is a quaternion stored as x, y, z and w,
+// color can have 3 or 4 channels. Missing values get the same defaults as in other formats.
+type cellPointJSON struct {
+	Layer        int
+	Position     *[3]float32
+	Orientation  *[4]float32 `json:",omitempty"`
+	Scale        *[3]float32 `json:",omitempty"`
+	PaletteIndex *int        `json:",omitempty"`
+	Color        []float32   `json:",omitempty"`
+}
+
+// writeCellPointsJSON writes points as JSON, one cell per line, so huge files aren't built in memory.
+func writeCellPointsJSON(writer io.Writer, points []CellPoint) error {
+	fmt.Fprintf(writer, "{\"Version\":%d,\"Cells\":[\n", cellPointsVersion)
+	for i := range points {
+		point := &points[i]
+		orientation := [4]float32{point.Orientation.V[0], point.Orientation.V[1], point.Orientation.V[2], point.Orientation.W}
+		scale := [3]float32(point.Scale)
+		position := [3]float32(point.Position)
+		paletteIndex := point.PaletteIndex
+		data, err := json.Marshal(cellPointJSON{point.Layer, &position, &orientation, &scale, &paletteIndex, point.Color[:]})
+		if err != nil {
+			return err
+		}
+		if i + 1 < len(points) {
+			data = append(data, ',')
+		}
+		data = append(data, '\n')
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(writer, "]}")
+	return err
+}
+
+func readCellPointsJSON(reader io.Reader) (*cellPointsFile, error) {
+	var content cellPointsJSON
+	if err := json.NewDecoder(bufio.NewReader(reader)).Decode(&content); err != nil {
+		return nil, err
+	}
+	if content.Version > cellPointsVersion {
+		return nil, fmt.Errorf("file has version %d, newer than supported version %d", content.Version, cellPointsVersion)
+	}
+
+	file := &cellPointsFile{points: make([]CellPoint, len(content.Cells)), hasColors: true, hasPaletteIndices: true}
+	for i := range content.Cells {
+		cell := &content.Cells[i]
+		if cell.Position == nil {
+			return nil, fmt.Errorf("cell %d has no position", i + 1)
+		}
+		point := CellPoint{
+			Layer:       cell.Layer,
+			Position:    mgl32.Vec3(*cell.Position),
+			Orientation: mgl32.QuatIdent(),
+			Scale:       mgl32.Vec3{1.0, 1.0, 1.0},
+		}
+		if cell.Orientation != nil {
+			point.Orientation = mgl32.Quat{W: cell.Orientation[3], V: mgl32.Vec3{cell.Orientation[0], cell.Orientation[1], cell.Orientation[2]}}
+		}
+		if cell.Scale != nil {
+			point.Scale = mgl32.Vec3(*cell.Scale)
+		}
+		// Palette indices and colors are used only if every cell has them.
+		if cell.PaletteIndex != nil {
+			point.PaletteIndex = *cell.PaletteIndex
+		} else {
+			file.hasPaletteIndices = false
+		}
+		switch len(cell.Color) {
+		case 0:
+			file.hasColors = false
+		case 3:
+			point.Color = mgl32.Vec4{cell.Color[0], cell.Color[1], cell.Color[2], 1.0}
+		case 4:
+			point.Color = mgl32.Vec4{cell.Color[0], cell.Color[1], cell.Color[2], cell.Color[3]}
+		default:
+			return nil, fmt.Errorf("cell %d has color with %d channels", i + 1, len(cell.Color))
+		}
+		file.points[i] = point
+	}
+	return file, nil
+}
+
+// writeCellPointsPLY writes points as vertices of binary PLY file, see cellPointColumns for their properties.
+func writeCellPointsPLY(writer io.Writer, points []CellPoint) error {
+	fmt.Fprintln(writer, "ply")
+	fmt.Fprintln(writer, "format binary_little_endian 1.0")
+	fmt.Fprintln(writer, "comment iris cell layout")
+	fmt.Fprintf(writer, "element vertex %d\n", len(points))
+	for _, name := range cellPointColumns {
+		valueType := "float"
+		if name == "layer" || name == "palette_index" {
+			valueType = "int"
+		}
+		fmt.Fprintf(writer, "property %s %s\n", valueType, name)
+	}
+	fmt.Fprintln(writer, "end_header")
+
+	var value [4]byte
+	data := make([]byte, 0, len(cellPointColumns) * len(value))
+	for i := range points {
+		data = data[:0]
+		for j, x := range getCellPointValues(&points[i]) {
+			if cellPointColumns[j] == "layer" || cellPointColumns[j] == "palette_index" {
+				binary.LittleEndian.PutUint32(value[:], uint32(int32(x)))
+			} else {
+				binary.LittleEndian.PutUint32(value[:], math.Float32bits(x))
+			}
+			data = append(data, value[:]...)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCellPointsPLY reads points from vertices of PLY file. Colors with integer type are
+// in [0, 255] range, as usual for PLY.
+func readCellPointsPLY(reader io.Reader) (*cellPointsFile, error) {
+	properties, err := geometry.ReadPLYElement(reader, "vertex")
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string][]float64)
+	count := 0
+	for name, property := range properties {
+		columns[name] = property.Values
+		count = len(property.Values)
+	}
+	colorScale := 1.0
+	if red, ok := properties["red"]; ok && red.IsInteger() {
+		colorScale = 1.0 / 255.0
+	}
+	return getCellPointsFile(columns, count, colorScale)
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"log"
+	"math"
+
+	"../lib/geometry"
+	"../lib/graphics"
+)
+
+// Name of the shape used when none is specified, it's the original look of iris.
+const DefaultCellShape = "box"
+
+// Bevel radius is rounded to multiples of this step, so dragging the bevel slider
+// creates only a limited number of meshes.
+const cellShapeBevelStep = 0.01
+
+// Number of sides of prism shaped cells.
+const cellPrismSides = 6
+
+// Ratio of tube diameter to outer diameter of torus shaped cells.
+const cellTorusThickness = 0.3
+
+// cellShape describes a shape cells can be drawn with.
+type cellShape struct {
+	name string
+	// Whether the shape has edges which can be rounded by bevel.
+	bevel    bool
+	generate func(bevel float64) geometry.MeshData
+}
+
+var cellShapes = []cellShape{
+	{DefaultCellShape, true, geometry.GetBox},
+	{"sphere", false, func(float64) geometry.MeshData { return geometry.GetSphere() }},
+	{"capsule", false, func(float64) geometry.MeshData { return geometry.GetCapsule() }},
+	{"cylinder", false, func(float64) geometry.MeshData { return geometry.GetCylinder() }},
+	{"prism", false, func(float64) geometry.MeshData { return geometry.GetPrism(cellPrismSides) }},
+	{"torus", false, func(float64) geometry.MeshData { return geometry.GetTorus(cellTorusThickness) }},
+}
+
+// cellShapeKey identifies a generated mesh of cell shape.
+type cellShapeKey struct {
+	name  string
+	bevel int
+}
+
+var cellShapeMeshes = make(map[cellShapeKey]graphics.Mesh)
+
+// cellMeshFile is mesh loaded from file, or the error loading failed with. GPU mesh
+// is created when it's drawn for the first time.
+type cellMeshFile struct {
+	data        geometry.MeshData
+	mesh        graphics.Mesh
+	meshCreated bool
+	err         error
+}
+
+var cellMeshFiles = make(map[string]*cellMeshFile)
+
+// getCellShape returns shape with name, or the default shape if there's no shape with such name.
+func getCellShape(name string) *cellShape {
+	for i := range cellShapes {
+		if cellShapes[i].name == name {
+			return &cellShapes[i]
+		}
+	}
+	return &cellShapes[0]
+}
+
+// GetNextCellShape returns name of shape following the one with name, wrapping around after the last one.
+func GetNextCellShape(name string) string {
+	for i, shape := range cellShapes {
+		if shape.name == name {
+			return cellShapes[(i + 1) % len(cellShapes)].name
+		}
+	}
+	return cellShapes[0].name
+}
+
+// CellShapeHasBevel reports whether edges of shape with name can be rounded.
+func CellShapeHasBevel(name string) bool {
+	return getCellShape(name).bevel
+}
+
+// LoadCellMesh loads mesh file at path, so cells can be drawn with it. Every file is loaded
+// just once, the result (or the error) is kept for the lifetime of the app.
+func LoadCellMesh(path string) error {
+	return loadCellMeshFile(path).err
+}
+
+func loadCellMeshFile(path string) *cellMeshFile {
+	file, ok := cellMeshFiles[path]
+	if !ok {
+		data, err := geometry.LoadMesh(path)
+		if err != nil {
+			log.Println("couldn't load cell mesh:", err)
+		}
+		file = &cellMeshFile{data: data, err: err}
+		cellMeshFiles[path] = file
+	}
+	return file
+}
+
+// getCellShapeKey returns key of generated mesh of shape in settings. Bevel is rounded
+// to cellShapeBevelStep and it's zero for shapes without bevel.
+func getCellShapeKey(settings *ShapeSettings) cellShapeKey {
+	shape := getCellShape(settings.Name)
+	key := cellShapeKey{shape.name, 0}
+	if shape.bevel {
+		bevel := math.Max(0.0, math.Min(settings.Bevel, geometry.MaxBevel))
+		key.bevel = int(math.Round(bevel / cellShapeBevelStep))
+	}
+	return key
+}
+
+// GetCellShapeMeshData returns vertices and indices of mesh cells with settings are drawn with.
+// Unlike GetCellShapeMesh, it doesn't need graphics to be initialized.
+func GetCellShapeMeshData(settings *ShapeSettings) geometry.MeshData {
+	if settings.Mesh != "" {
+		if file := loadCellMeshFile(settings.Mesh); file.err == nil {
+			return file.data
+		}
+	}
+	key := getCellShapeKey(settings)
+	return getCellShape(key.name).generate(float64(key.bevel) * cellShapeBevelStep)
+}
+
+// GetCellShapeMesh returns mesh cells with settings are drawn with. Meshes are generated
+// the first time they're needed and kept for the lifetime of the app. Cells with mesh file
+// which can't be loaded are drawn with the shape with Name.
+func GetCellShapeMesh(settings *ShapeSettings) graphics.Mesh {
+	if settings.Mesh != "" {
+		if file := loadCellMeshFile(settings.Mesh); file.err == nil {
+			if !file.meshCreated {
+				file.mesh = graphics.GetMesh(file.data.Vertices, file.data.Indices, geometry.VertexAttribs)
+				file.meshCreated = true
+			}
+			return file.mesh
+		}
+	}
+	key := getCellShapeKey(settings)
+	mesh, ok := cellShapeMeshes[key]
+	if !ok {
+		data := getCellShape(key.name).generate(float64(key.bevel) * cellShapeBevelStep)
+		mesh = graphics.GetMesh(data.Vertices, data.Indices, geometry.VertexAttribs)
+		cellShapeMeshes[key] = mesh
+	}
+	return mesh
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Maximum number of rotated copies of cells.
+const MaxSymmetryFold = 24
+
+// getSymmetryFold returns number of rotated copies of cells, clamped to valid range.
+func getSymmetryFold(settings *SymmetrySettings) int {
+	return int(math.Max(1, math.Min(float64(settings.Fold), MaxSymmetryFold)))
+}
+
+// getSymmetryMirrors returns mirroring transforms of planes selected in settings.
+func getSymmetryMirrors(settings *SymmetrySettings) []mgl32.Mat4 {
+	var mirrors []mgl32.Mat4
+	if settings.MirrorX {
+		mirrors = append(mirrors, mgl32.Scale3D(-1, 1, 1))
+	}
+	if settings.MirrorY {
+		mirrors = append(mirrors, mgl32.Scale3D(1, -1, 1))
+	}
+	if settings.MirrorZ {
+		mirrors = append(mirrors, mgl32.Scale3D(1, 1, -1))
+	}
+	return mirrors
+}
+
+// getSymmetryCopies returns number of copies every cell has with settings, including the cell itself.
+func getSymmetryCopies(settings *SymmetrySettings) int {
+	return getSymmetryFold(settings) << uint(len(getSymmetryMirrors(settings)))
+}
+
+// getSymmetryTransforms returns world space transforms of copies of a cell, the first one is identity.
+// Copies are rotated around the y axis and mirrored by every combination of the selected planes.
+func getSymmetryTransforms(settings *SymmetrySettings) []mgl32.Mat4 {
+	fold := getSymmetryFold(settings)
+	mirrors := getSymmetryMirrors(settings)
+	transforms := make([]mgl32.Mat4, 0, fold << uint(len(mirrors)))
+	for mask := 0; mask < 1 << uint(len(mirrors)); mask++ {
+		mirror := mgl32.Ident4()
+		for i := range mirrors {
+			if mask & (1 << uint(i)) != 0 {
+				mirror = mirror.Mul4(mirrors[i])
+			}
+		}
+		for i := 0; i < fold; i++ {
+			transforms = append(transforms, mirror.Mul4(mgl32.HomogRotate3DY(float32(2.0 * math.Pi * float64(i) / float64(fold)))))
+		}
+	}
+	return transforms
+}
+
+// getSymmetricCells returns the first count cells of layer with symmetry settings. Copies of a cell
+// follow each other and they're all the same cell, so they share its color.
+func getSymmetricCells(cells []Cell, count int, settings *SymmetrySettings) []Cell {
+	copies := getSymmetryCopies(settings)
+	if copies == 1 {
+		return cells[:count]
+	}
+	symmetricCells := make([]Cell, count)
+	for i := range symmetricCells {
+		symmetricCells[i] = cells[i / copies]
+	}
+	return symmetricCells
+}
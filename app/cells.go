@@ -3,76 +3,265 @@ package app
 import (
 	"math"
 	"math/rand"
+	"runtime"
+	"sync"
+	"time"
 	"github.com/go-gl/mathgl/mgl32"
 )
 
 // Constants.
 const minColorMultiplier = 0.5
 const maxColorMultiplier = 1.5
-const minNormalizedScaleX = 0.2
-const minNormalizedScaleZ = 0.2
-const squaredScaleX = 2.75
-const squaredScaleZ = 4.0
+const minCellScale = 0.001
 
-// Cell represents single element in the image.
+// Minimum number of cells processed by a single goroutine, smaller batches aren't worth the overhead.
+const minCellsPerGoroutine = 256
+
+// Names of distributions of cell dimensions.
+const (
+	ScaleDistributionSquared   = "squared"
+	ScaleDistributionLinear    = "linear"
+	ScaleDistributionLogNormal = "lognormal"
+)
+
+var scaleDistributions = []string{
+	ScaleDistributionSquared,
+	ScaleDistributionLinear,
+	ScaleDistributionLogNormal,
+}
+
+// Cell represents single element in the image. Its dimensions are stored normalized,
+// so they can be changed without changing the rest of the cell.
 type Cell struct {
 	polar, azimuth, radius float64
-	scale                mgl32.Vec3
+	width, depth           float64
 	colorMultiplier      float32
 	colorIndex           int
 }
 
-// GenerateCells initializes array of cells with random values.
-func GenerateCells(cells []Cell) {
+// Seed of cells in default settings.
+const defaultCellsSeed = 1
+
+// GetRandomSeed returns a new seed for GenerateCells.
+func GetRandomSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// GenerateCells initializes array of cells with random values. The same seed
+// always generates the same cells.
+func GenerateCells(cells []Cell, seed int64) {
+	random := rand.New(rand.NewSource(seed))
 	for i := range cells {
-		// Get scale vector.
-		scaleX := rand.Float32() * (1.0 - minNormalizedScaleX) + minNormalizedScaleX
-		scaleZ := rand.Float32() * (1.0 - minNormalizedScaleZ) + minNormalizedScaleZ
-		scaleX *= scaleX * squaredScaleX
-		scaleZ *= scaleZ * squaredScaleZ
-		scaleY := (scaleX + scaleZ) / 2.0
-		scale := mgl32.Vec3{scaleX, scaleY, scaleZ}
+		// Get normalized dimensions.
+		width := float64(random.Float32())
+		depth := float64(random.Float32())
 
 		// Get polar coordinates.
-		polar := rand.NormFloat64()
-		azimuth := rand.Float64() * math.Pi * 2
-		radius := rand.Float64()
+		polar := random.NormFloat64()
+		azimuth := random.Float64() * math.Pi * 2
+		radius := random.Float64()
 
 		// Get random parameters for colors.
-		colorMultiplier := rand.Float32() * (maxColorMultiplier - minColorMultiplier) + minColorMultiplier
-		colorIndex := rand.Int()
-		cells[i] = Cell{polar, azimuth, radius, scale, colorMultiplier, colorIndex}
+		colorMultiplier := random.Float32() * (maxColorMultiplier - minColorMultiplier) + minColorMultiplier
+		colorIndex := random.Int()
+		cells[i] = Cell{polar, azimuth, radius, width, depth, colorMultiplier, colorIndex}
 	}
 }
 
 // GetCellModelMatrices returns an array of model matrices, each transforming a single cell into world space.
-func GetCellModelMatrices(cells []Cell, radiusMin, radiusMax, polarStd, polarMean, heightRatio float64, count int) []mgl32.Mat4{
+// Cells are placed by distribution selected in settings.
+func GetCellModelMatrices(cells []Cell, settings CellSettings) []mgl32.Mat4{
+	matrices := make([]mgl32.Mat4, settings.Count)
+	fillCellModelMatrices(matrices, cells, &settings)
+	return matrices
+}
+
+// fillCellModelMatrices computes model matrices of the first len(matrices) cells. Cells are processed in parallel.
+// Layers placed by point cloud take matrices from its points, there must be at least len(matrices) of them.
+// Other layers can be symmetric and keep their cells apart, see getSymmetryTransforms and separateCells.
+// Both are animated at time of animation clock and get overrides of cells applied.
+func fillCellModelMatrices(matrices []mgl32.Mat4, cells []Cell, settings *CellSettings) {
+	placeCellMatrices(matrices, cells, settings)
+	applyCellScales(matrices, getCellSeparation(cells, len(matrices), settings))
+}
+
+// getCellSeparation returns scales which keep the first count cells with settings apart, see separateCells.
+// It returns nil if cells aren't kept apart. Cells are separated as they're placed without animation,
+// so they don't change their size as they move.
+func getCellSeparation(cells []Cell, count int, settings *CellSettings) []float32 {
+	if points, _ := getCellPoints(&settings.Points); points != nil || !settings.Overlap.Avoid {
+		return nil
+	}
+	unanimated := *settings
+	unanimated.Animation = AnimationSettings{}
 	matrices := make([]mgl32.Mat4, count)
-	
-	for i, cell := range cells[:count] {
-		// Get position in cartesian coordinates.
-		polar := cell.polar * polarStd + polarMean
-		azimuth := cell.azimuth
-		radius := cell.radius * (radiusMax - radiusMin) + radiusMin
-		position := vecFromPolarCoords(azimuth, polar, radius)
-
-		// Construct model matrix.
-		scaleMatrix := mgl32.Scale3D(cell.scale[0], cell.scale[1], cell.scale[2])
-		modelMatrix := mgl32.LookAt(position[0], position[1], position[2], 0, 0, 0, 0, 1, 0).Inv().Mul4(scaleMatrix)
-
-		matrices[i] = modelMatrix
+	placeCellMatrices(matrices, cells, &unanimated)
+	return separateCells(matrices, settings.Overlap.MinGap)
+}
+
+// placeCellMatrices computes model matrices of the first len(matrices) cells, as fillCellModelMatrices
+// does, but cells aren't kept apart.
+func placeCellMatrices(matrices []mgl32.Mat4, cells []Cell, settings *CellSettings) {
+	if points, _ := getCellPoints(&settings.Points); points != nil {
+		fillPointModelMatrices(matrices, points)
+		animateCellMatrices(matrices, cells, &settings.Animation)
+		applyCellOverrideMatrices(matrices, settings)
+		return
 	}
-	return matrices
+	// Only enough cells to fill matrices with their copies are placed, they're placed as if there were no others.
+	copies := getSymmetryCopies(&settings.Symmetry)
+	count := (len(matrices) + copies - 1) / copies
+	placed := matrices
+	if copies > 1 {
+		placed = make([]mgl32.Mat4, count)
+	}
+	distribution := GetCellDistribution(settings.Distribution.Name)
+	parameters := GetDistributionParameters(&settings.Distribution)
+
+	forEachCellParallel(count, func(start, end int) {
+		for i := start; i < end; i++ {
+			// Get position in cartesian coordinates.
+			position, target := distribution.Place(&cells[i], i, count, settings, parameters)
+
+			// Construct model matrix.
+			scale := getCellScale(&cells[i], settings)
+			scaleMatrix := mgl32.Scale3D(scale[0], scale[1], scale[2])
+			placed[i] = getLookAtMatrix(position, target).Inv().Mul4(scaleMatrix)
+		}
+	})
+	// Copies of cells move with them, so the layer stays symmetric.
+	animateCellMatrices(placed, cells, &settings.Animation)
+	if copies > 1 {
+		transforms := getSymmetryTransforms(&settings.Symmetry)
+		forEachCellParallel(len(matrices), func(start, end int) {
+			for i := start; i < end; i++ {
+				matrices[i] = transforms[i % copies].Mul4(placed[i / copies])
+			}
+		})
+	}
+	applyCellOverrideMatrices(matrices, settings)
 }
 
-// GetCellColors returns an array of color vectors, each for a single cell.
+// forEachCellParallel calls body for ranges of cell indices covering [0, count). Ranges are
+// processed concurrently, body must not modify anything shared between them.
+func forEachCellParallel(count int, body func(start, end int)) {
+	workers := runtime.NumCPU()
+	if maxWorkers := (count + minCellsPerGoroutine - 1) / minCellsPerGoroutine; maxWorkers < workers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		body(0, count)
+		return
+	}
+
+	var wait sync.WaitGroup
+	batchSize := (count + workers - 1) / workers
+	for start := 0; start < count; start += batchSize {
+		end := start + batchSize
+		if end > count {
+			end = count
+		}
+		wait.Add(1)
+		go func(start, end int) {
+			defer wait.Done()
+			body(start, end)
+		}(start, end)
+	}
+	wait.Wait()
+}
+
+// GetNextScaleDistribution returns name of scale distribution following the one with name,
+// wrapping around after the last one.
+func GetNextScaleDistribution(name string) string {
+	for i, distribution := range scaleDistributions {
+		if distribution == name {
+			return scaleDistributions[(i + 1) % len(scaleDistributions)]
+		}
+	}
+	return scaleDistributions[0]
+}
+
+// getCellScale returns dimensions of cell. Height of cell is the average of its width
+// and depth, multiplied by height ratio.
+func getCellScale(cell *Cell, settings *CellSettings) mgl32.Vec3 {
+	scale := &settings.Scale
+	width := getScale(cell.width, scale.WidthMin, scale.WidthMax, scale.Distribution)
+	depth := getScale(cell.depth, scale.DepthMin, scale.DepthMax, scale.Distribution)
+	if scale.Uniform {
+		depth = width
+	}
+	height := (width + depth) / 2.0 * settings.HeightRatio
+	return mgl32.Vec3{float32(width), float32(height), float32(depth)}
+}
+
+// getScale maps normalized dimension t to range between min and max using distribution.
+func getScale(t, min, max float64, distribution string) float64 {
+	min, max = math.Max(min, minCellScale), math.Max(max, minCellScale)
+	switch distribution {
+	case ScaleDistributionLinear:
+		return lerp(min, max, t)
+	case ScaleDistributionLogNormal:
+		// The range covers two standard deviations on both sides of the mean.
+		mean := (math.Log(min) + math.Log(max)) / 2.0
+		std := (math.Log(max) - math.Log(min)) / 4.0
+		normal := math.Sqrt2 * math.Erfinv(2.0 * clamp(t, 1e-6, 1.0 - 1e-6) - 1.0)
+		return clamp(math.Exp(mean + std * normal), math.Min(min, max), math.Max(min, max))
+	}
+	// Squared distribution favours small cells, it's the original look of iris.
+	return math.Pow(lerp(math.Sqrt(min), math.Sqrt(max), t), 2.0)
+}
+
+// getLookAtMatrix returns view matrix of cell at position facing target. Up vector is changed
+// when the cell would look straight up or down, since there's no valid view matrix otherwise.
+func getLookAtMatrix(position, target mgl32.Vec3) mgl32.Mat4 {
+	up := mgl32.Vec3{0, 1, 0}
+	direction := target.Sub(position)
+	if direction.Len() < 1e-6 {
+		direction = mgl32.Vec3{0, 0, -1}
+		target = position.Add(direction)
+	}
+	if direction.Normalize().Cross(up).Len() < 1e-3 {
+		up = mgl32.Vec3{0, 0, 1}
+	}
+	return mgl32.LookAtV(position, target, up)
+}
+
+// GetCellColors returns an array of color vectors, each for a single cell. Colors are assigned randomly.
 func GetCellColors(cells []Cell, colorPalette []mgl32.Vec4, count int) []mgl32.Vec4{
 	colors := make([]mgl32.Vec4, count)
-	colorPaletteSize := len(colorPalette)
+	fillCellColors(colors, cells, nil, &defaultCellSettings, colorPalette)
+	return colors
+}
 
-	for i, cell := range cells[:count] {
-		colorIndex := cell.colorIndex % colorPaletteSize
-		colors[i] = colorPalette[colorIndex].Mul(cell.colorMultiplier)
+// fillCellColors computes colors of the first len(colors) cells, assigned by coloring of settings.
+// Matrices of the cells are needed only if the coloring uses positions.
+func fillCellColors(colors []mgl32.Vec4, cells []Cell, matrices []mgl32.Mat4, settings *CellSettings, colorPalette []mgl32.Vec4) {
+	cells = cells[:len(colors)]
+	coords := getPaletteCoords(cells, matrices, &settings.Coloring, settings.Seed, len(colorPalette))
+	for i := range cells {
+		colors[i] = getPaletteColor(colorPalette, coords[i]).Mul(cells[i].colorMultiplier)
 	}
+}
+
+// GetCellColorsTransition returns an array of color vectors of cells transitioning between two palettes,
+// t goes from 0 (fromPalette) to 1 (toPalette). Palettes of different sizes assign colors to cells
+// differently, so they can't be transitioned color by color.
+func GetCellColorsTransition(cells []Cell, fromPalette, toPalette []mgl32.Vec4, t float32, count int) []mgl32.Vec4{
+	colors := make([]mgl32.Vec4, count)
+	fillCellColorsTransition(colors, cells, nil, &defaultCellSettings, fromPalette, toPalette, t)
 	return colors
-}
\ No newline at end of file
+}
+
+// fillCellColorsTransition computes colors of the first len(colors) cells transitioning between two palettes,
+// see fillCellColors.
+func fillCellColorsTransition(colors []mgl32.Vec4, cells []Cell, matrices []mgl32.Mat4, settings *CellSettings,
+	fromPalette, toPalette []mgl32.Vec4, t float32) {
+	cells = cells[:len(colors)]
+	fromCoords := getPaletteCoords(cells, matrices, &settings.Coloring, settings.Seed, len(fromPalette))
+	toCoords := getPaletteCoords(cells, matrices, &settings.Coloring, settings.Seed, len(toPalette))
+	for i := range cells {
+		fromColor := getPaletteColor(fromPalette, fromCoords[i])
+		toColor := getPaletteColor(toPalette, toCoords[i])
+		colors[i] = fromColor.Mul(1.0 - t).Add(toColor.Mul(t)).Mul(cells[i].colorMultiplier)
+	}
+}
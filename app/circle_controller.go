@@ -86,6 +86,11 @@ func (controller *CircleController) Update(dt float64, mouseXWorld, mouseZWorld
 	controller.Angle.Update(dt, angleUpdateSpeed)
 }
 
+// IsHot reports whether mouse is over the controller or the controller is being dragged.
+func (controller *CircleController) IsHot() bool {
+	return controller.hot || controller.active
+}
+
 // GetMeshData returns vertex and index arrays for circle controller's mesh.
 func (controller *CircleController) GetMeshData() ([]float32, []uint32) {
 	vertices := make([]float32, 0)
@@ -5,13 +5,33 @@ import (
 	"net/http"
 	"encoding/json"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"time"
 
 	"github.com/go-gl/mathgl/mgl32"
 )
 
+// Limits of palette size.
+const MinPaletteSize = 1
+const MaxPaletteSize = 16
 
-// GetRandomColorPalette returns a random color palette of 5 colors.
-func GetRandomColorPalette() [] mgl32.Vec4{
+// Number of colors in palettes returned by colormind.io.
+const colormindPaletteSize = 5
+
+// GetRandomColorPalette returns a random color palette of specified size. Palette is generated
+// by colormind.io, if it can't be reached, palette of random hues is generated instead.
+func GetRandomColorPalette(size int) []mgl32.Vec4 {
+	colors := getColormindPalette()
+	if colors == nil {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return GetRandomHuePalette(size, random)
+	}
+	return ResizeColorPalette(colors, size)
+}
+
+// getColormindPalette returns a random palette of 5 colors from colormind.io, or nil on failure.
+func getColormindPalette() []mgl32.Vec4 {
 	// Send a request to colormind.io.
 	var requestData = []byte (`{"model": "default"}`)
 	res, err := http.Post("http://colormind.io/api/", "text/json", bytes.NewBuffer(requestData))
@@ -19,22 +39,25 @@ func GetRandomColorPalette() [] mgl32.Vec4{
 		return nil
 	}
 	defer res.Body.Close()
-	
+
 	// Read the response and get its body as a `map[string]([5][]int)`.
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil
 	}
-	var responseValue = make(map[string]([5][]int))
+	var responseValue = make(map[string]([colormindPaletteSize][]int))
 	err = json.Unmarshal(body, &responseValue)
 	if err != nil {
 		return nil
 	}
 	responseColors := responseValue["result"]
-	
+
 	// Convert colors in response to Vec4s.
-	var colors [5]mgl32.Vec4
+	var colors [colormindPaletteSize]mgl32.Vec4
 	for i, color := range responseColors {
+		if len(color) < 3 {
+			return nil
+		}
 		colors[i] = mgl32.Vec4{
 			float32(color[0]) / 255.0,
 			float32(color[1]) / 255.0,
@@ -44,4 +67,77 @@ func GetRandomColorPalette() [] mgl32.Vec4{
 	}
 
 	return colors[:]
-}
\ No newline at end of file
+}
+
+// GetRandomHuePalette returns palette of specified size with hues spread evenly
+// around the color wheel, starting at a random hue.
+func GetRandomHuePalette(size int, random *rand.Rand) []mgl32.Vec4 {
+	size = clampPaletteSize(size)
+	colors := make([]mgl32.Vec4, size)
+	hue := random.Float64()
+	saturation := 0.5 + random.Float64() * 0.4
+	for i := range colors {
+		// Vary brightness, so neighbouring colors are easier to tell apart.
+		value := 0.55 + random.Float64() * 0.4
+		colors[i] = hsvToRGB(math.Mod(hue + float64(i) / float64(size), 1.0), saturation, value)
+	}
+	return colors
+}
+
+// ResizeColorPalette returns palette of specified size with colors sampled from
+// colors. Sampled colors are linearly interpolated, so the gradient of the palette
+// is preserved.
+func ResizeColorPalette(colors []mgl32.Vec4, size int) []mgl32.Vec4 {
+	size = clampPaletteSize(size)
+	resized := make([]mgl32.Vec4, size)
+	if len(colors) == 0 {
+		return resized
+	}
+	for i := range resized {
+		position := 0.0
+		if size > 1 {
+			position = float64(i) / float64(size - 1) * float64(len(colors) - 1)
+		}
+		index := int(position)
+		if index >= len(colors) - 1 {
+			resized[i] = colors[len(colors) - 1]
+			continue
+		}
+		t := float32(position - float64(index))
+		resized[i] = colors[index].Mul(1.0 - t).Add(colors[index + 1].Mul(t))
+	}
+	return resized
+}
+
+func clampPaletteSize(size int) int {
+	if size < MinPaletteSize {
+		return MinPaletteSize
+	} else if size > MaxPaletteSize {
+		return MaxPaletteSize
+	}
+	return size
+}
+
+// hsvToRGB converts color from HSV (all components in [0, 1] range) to opaque RGBA.
+func hsvToRGB(h, s, v float64) mgl32.Vec4 {
+	h6 := h * 6.0
+	c := v * s
+	x := c * (1.0 - math.Abs(math.Mod(h6, 2.0) - 1.0))
+	var r, g, b float64
+	switch int(h6) % 6 {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := v - c
+	return mgl32.Vec4{float32(r + m), float32(g + m), float32(b + m), 1.0}
+}
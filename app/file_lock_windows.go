@@ -0,0 +1,32 @@
+package app
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const lockfileExclusiveLock = 0x2
+
+// LockFileEx/UnlockFileEx are not exposed by syscall package, so we'll load them from kernel32.dll.
+var kernel32 = syscall.NewLazyDLL("kernel32.dll")
+var procLockFileEx = kernel32.NewProc("LockFileEx")
+var procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+
+func lockFileHandle(file *os.File) error {
+	var overlapped syscall.Overlapped
+	ret, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlockFileHandle(file *os.File) error {
+	var overlapped syscall.Overlapped
+	ret, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
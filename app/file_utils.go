@@ -0,0 +1,70 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to path and then renames it to path.
+// This way readers (and the file itself in case of crash) never see partially written data.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	// Temporary file name starts with a dot, so it's skipped when loading saves.
+	tmpFile, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// fileLock is an advisory lock on a file, used to coordinate multiple running
+// instances of iris which access the same files.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile blocks until it acquires exclusive lock on file at path. The file is created if necessary.
+func lockFile(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFileHandle(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileLock{file}, nil
+}
+
+// Unlock releases the lock.
+func (lock *fileLock) Unlock() error {
+	err := unlockFileHandle(lock.file)
+	if closeErr := lock.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
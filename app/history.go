@@ -0,0 +1,220 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Maximum number of steps which can be undone.
+const historyLimit = 100
+
+// historyStep is a single undoable change - either a change of settings
+// or deletion of a preset.
+type historyStep struct {
+	before, after AppSettings
+	// Whether undoing/redoing the step moves the camera as well. Camera moves
+	// by themselves are not recorded, only changes such as preset selection do that.
+	restoreCamera bool
+
+	// Deleted preset, restored when the step is undone.
+	preset *AppSettings
+}
+
+// History records snapshots of settings, so their changes can be undone and redone.
+type History struct {
+	undoSteps []historyStep
+	redoSteps []historyStep
+	// Settings as they were after the last recorded step.
+	current AppSettings
+}
+
+// HistoryChange describes what has to be updated after undo or redo.
+type HistoryChange struct {
+	// Settings to apply, nil if settings are not changed.
+	Settings      *AppSettings
+	RestoreCamera bool
+
+	// Whether a preset was restored - it's appended to the end of presets.
+	PresetRestored bool
+	// Index of removed preset, -1 if no preset was removed.
+	PresetRemoved int
+}
+
+// GetHistory returns empty history starting at settings.
+func GetHistory(settings AppSettings) History {
+	return History{current: copySettings(&settings)}
+}
+
+// Update records settings as a new step if they changed since the last step. While editing
+// is in progress (e.g. slider is being dragged), nothing is recorded, so the whole drag
+// ends up as a single step.
+func (history *History) Update(settings AppSettings, editing bool) {
+	if !editing {
+		history.Record(settings, false)
+	}
+	// Camera moves are not recorded, but steps restoring camera should restore the latest one.
+	history.current.Camera = settings.Camera
+}
+
+// Record records settings as a new step if they changed since the last step.
+// If restoreCamera is set, undoing the step moves the camera back as well.
+func (history *History) Record(settings AppSettings, restoreCamera bool) {
+	if isSameSettings(&history.current, &settings, restoreCamera) {
+		return
+	}
+	step := historyStep{before: history.current, after: copySettings(&settings), restoreCamera: restoreCamera}
+	history.push(step)
+	history.current = step.after
+}
+
+// Sync sets settings which further changes are compared against, without recording a step.
+func (history *History) Sync(settings AppSettings) {
+	history.current = copySettings(&settings)
+}
+
+// DeleteSettings deletes preset at index and records the deletion, so it can be undone.
+// It returns the number of saved presets.
+func (history *History) DeleteSettings(index int) (int, error) {
+	preset := settingsList[index]
+	count, err := DeleteSettings(index)
+	if err != nil {
+		return count, err
+	}
+	history.push(historyStep{preset: &preset})
+	return count, nil
+}
+
+func (history *History) push(step historyStep) {
+	history.undoSteps = append(history.undoSteps, step)
+	if len(history.undoSteps) > historyLimit {
+		history.undoSteps = history.undoSteps[1:]
+	}
+	history.redoSteps = nil
+}
+
+// Undo reverts the last step. Changes of settings which were not recorded yet are
+// recorded first, so they're the ones being reverted.
+func (history *History) Undo(settings AppSettings) (HistoryChange, error) {
+	history.Record(settings, false)
+	change := HistoryChange{PresetRemoved: -1}
+	if len(history.undoSteps) == 0 {
+		return change, nil
+	}
+	step := &history.undoSteps[len(history.undoSteps) - 1]
+
+	if step.preset != nil {
+		_, err := RestoreSettings(*step.preset)
+		if err != nil {
+			return change, err
+		}
+		// Restored preset gets a new id in store, redo has to delete that one.
+		step.preset.id = settingsList[len(settingsList) - 1].id
+		change.PresetRestored = true
+	} else {
+		settings := copySettings(&step.before)
+		change.Settings = &settings
+		change.RestoreCamera = step.restoreCamera
+		history.current = step.before
+	}
+
+	history.redoSteps = append(history.redoSteps, *step)
+	history.undoSteps = history.undoSteps[:len(history.undoSteps) - 1]
+	return change, nil
+}
+
+// Redo applies the last undone step again.
+func (history *History) Redo(settings AppSettings) (HistoryChange, error) {
+	change := HistoryChange{PresetRemoved: -1}
+	// Redo steps are only valid if nothing has changed since the undo.
+	if len(history.redoSteps) == 0 || !isSameSettings(&history.current, &settings, false) {
+		return change, nil
+	}
+	step := history.redoSteps[len(history.redoSteps) - 1]
+
+	if step.preset != nil {
+		index := getSettingsIndex(step.preset.id)
+		if index < 0 {
+			return change, errors.New("preset doesn't exist anymore")
+		}
+		_, err := DeleteSettings(index)
+		if err != nil {
+			return change, err
+		}
+		change.PresetRemoved = index
+	} else {
+		settings := copySettings(&step.after)
+		change.Settings = &settings
+		change.RestoreCamera = step.restoreCamera
+		history.current = step.after
+	}
+
+	history.undoSteps = append(history.undoSteps, step)
+	history.redoSteps = history.redoSteps[:len(history.redoSteps) - 1]
+	return change, nil
+}
+
+// isSameSettings reports whether settings a and b look the same. Camera is compared
+// only if compareCamera is set, metadata, version and id are never compared. It's called
+// every frame, so settings are compared in place, without copying them.
+func isSameSettings(a, b *AppSettings, compareCamera bool) bool {
+	if compareCamera && a.Camera != b.Camera {
+		return false
+	}
+	return a.Rendering == b.Rendering && isSameValue(reflect.ValueOf(a.Layers), reflect.ValueOf(b.Layers))
+}
+
+// isSameValue reports whether a and b are deeply equal, like reflect.DeepEqual, except that nil
+// and empty slices and maps are the same. Copied settings keep nil, while edited ones end up
+// with empty ones.
+func isSameValue(a, b reflect.Value) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !isSameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iterator := a.MapRange()
+		for iterator.Next() {
+			value := b.MapIndex(iterator.Key())
+			if !value.IsValid() || !isSameValue(iterator.Value(), value) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !isSameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return isSameValue(a.Elem(), b.Elem())
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+	return a.CanInterface() && b.CanInterface() && reflect.DeepEqual(a.Interface(), b.Interface())
+}
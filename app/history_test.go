@@ -0,0 +1,19 @@
+package app
+
+import "testing"
+
+func TestHistoryIgnoresNoOpOverrideEdit(t *testing.T) {
+	settings := copySettings(&defaultSettings)
+	history := GetHistory(settings)
+
+	// Override which doesn't change anything leaves empty overrides instead of nil ones.
+	SetCellOverride(&settings.Layers[0], GetCellOverride(&settings.Layers[0], 0))
+	history.Update(settings, false)
+	expectValue(t, "undo steps", len(history.undoSteps), 0)
+
+	override := GetCellOverride(&settings.Layers[0], 0)
+	override.Hidden = true
+	SetCellOverride(&settings.Layers[0], override)
+	history.Update(settings, false)
+	expectValue(t, "undo steps", len(history.undoSteps), 1)
+}
@@ -18,3 +18,7 @@ func vecFromPolarCoords(azimuth float64, polar float64, radius float64) mgl32.Ve
 func clamp(val, min, max float64) float64 {
 	return math.Max(min, math.Min(val, max))
 }
+
+func abs32(x float32) float32 {
+	return float32(math.Abs(float64(x)))
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+
+	"../lib/graphics"
+)
+
+// Number of floats per cell in instance data of cells with transforms computed on GPU.
+const cellInstanceSize = 8
+
+// Color index of cell is stored modulo the least common multiple of all palette sizes
+// up to MaxPaletteSize, so it stays exact as float and still selects the same color.
+const cellColorIndexModulo = 720720
+
+// Pipelines computing transforms of cells in vertex shader.
+var pipelinePBRCells graphics.Pipeline
+var pipelineGeometryCells graphics.Pipeline
+
+// cellsData stores data of cells to be drawn with transforms computed on GPU.
+type cellsData struct {
+	mesh      graphics.Mesh
+	instances graphics.InstanceBuffer
+	count     int32
+	settings  *CellSettings
+
+	previousPalette   []mgl32.Vec4
+	paletteTransition float32
+}
+
+var cellEntities []cellsData
+
+func initCellsRendering() {
+	pipelinePBRCells = graphics.GetPipeline(
+		"shaders/cell_vertex_shader_instanced.glsl",
+		"shaders/pbr_pixel_shader.glsl")
+	pipelineGeometryCells = graphics.GetPipeline(
+		"shaders/cell_vertex_shader_instanced.glsl",
+		"shaders/geometry_pixel_shader.glsl")
+	cellEntities = make([]cellsData, 0, MaxLayers)
+}
+
+// SupportsGPUTransforms reports whether transforms of cells with settings can be computed on GPU.
+// Only the default distribution and coloring are implemented in shaders, others, point clouds, cells
+// avoiding overlaps, symmetric layers and layers with overridden cells are placed on CPU.
+func SupportsGPUTransforms(settings *CellSettings) bool {
+	if points, _ := getCellPoints(&settings.Points); points != nil || settings.Overlap.Avoid || hasCellOverrides(settings) ||
+		getSymmetryCopies(&settings.Symmetry) > 1 || GetCellColoring(settings.Coloring.Name).Name() != DefaultColoringName {
+		return false
+	}
+	return GetCellDistribution(settings.Distribution.Name).Name() == DefaultDistributionName
+}
+
+// GetCellInstanceData returns compact instance data of cells for DrawCells. It only depends
+// on cells, so it needs to be uploaded just once after cells are generated.
+func GetCellInstanceData(cells []Cell) []float32 {
+	data := make([]float32, len(cells) * cellInstanceSize)
+	for i, cell := range cells {
+		instance := data[i * cellInstanceSize : (i + 1) * cellInstanceSize]
+		instance[0] = float32(cell.polar)
+		instance[1] = float32(cell.azimuth)
+		instance[2] = float32(cell.radius)
+		instance[3] = cell.colorMultiplier
+		instance[4] = float32(cell.width)
+		instance[5] = float32(cell.depth)
+		instance[6] = float32(cell.colorIndex % cellColorIndexModulo)
+		instance[7] = getCellPhase(&cell)
+	}
+	return data
+}
+
+// DrawCells sets cells to be drawn in scene next frame. Their transforms and colors are computed
+// on GPU from instance data (see GetCellInstanceData) and settings, so changing settings doesn't
+// require any instance data to be updated. Colors transition from previousPalette to settings' palette.
+func DrawCells(mesh graphics.Mesh, instances graphics.InstanceBuffer, settings *CellSettings,
+	previousPalette []mgl32.Vec4, paletteTransition float32) {
+	cellEntities = append(cellEntities, cellsData{mesh, instances, int32(settings.Count), settings, previousPalette, paletteTransition})
+}
+
+func drawCells(pipeline graphics.Pipeline, cells *cellsData) {
+	settings := cells.settings
+	pipeline.SetUniform("polar_std", float32(settings.PolarStd))
+	pipeline.SetUniform("polar_mean", float32(settings.PolarMean))
+	pipeline.SetUniform("radius_min", float32(settings.RadiusMin))
+	pipeline.SetUniform("radius_max", float32(settings.RadiusMax))
+	pipeline.SetUniform("height_ratio", float32(settings.HeightRatio))
+
+	scaleDistribution := int32(0)
+	for i, distribution := range scaleDistributions {
+		if distribution == settings.Scale.Distribution {
+			scaleDistribution = int32(i)
+		}
+	}
+	uniformScale := int32(0)
+	if settings.Scale.Uniform {
+		uniformScale = 1
+	}
+	pipeline.SetUniform("scale_distribution", scaleDistribution)
+	pipeline.SetUniform("width_range", mgl32.Vec2{float32(settings.Scale.WidthMin), float32(settings.Scale.WidthMax)})
+	pipeline.SetUniform("depth_range", mgl32.Vec2{float32(settings.Scale.DepthMin), float32(settings.Scale.DepthMax)})
+	pipeline.SetUniform("uniform_scale", uniformScale)
+
+	animation := &settings.Animation
+	pipeline.SetUniform("animation_time", float32(getAnimationClock()))
+	pipeline.SetUniform("orbit", mgl32.Vec2{float32(animation.Orbit.Speed), float32(animation.Orbit.Amplitude)})
+	pipeline.SetUniform("breathe", mgl32.Vec2{float32(animation.Breathe.Speed), float32(animation.Breathe.Amplitude)})
+	pipeline.SetUniform("wave", mgl32.Vec2{float32(animation.Wave.Speed), float32(animation.Wave.Amplitude)})
+	pipeline.SetUniform("spin", mgl32.Vec2{float32(animation.Spin.Speed), float32(animation.Spin.Amplitude)})
+
+	pipeline.SetUniform("palette", settings.Colors)
+	pipeline.SetUniform("palette_size", int32(len(settings.Colors)))
+	if cells.paletteTransition < 1.0 && len(cells.previousPalette) > 0 {
+		pipeline.SetUniform("previous_palette", cells.previousPalette)
+		pipeline.SetUniform("previous_palette_size", int32(len(cells.previousPalette)))
+		pipeline.SetUniform("palette_transition", cells.paletteTransition)
+	} else {
+		pipeline.SetUniform("previous_palette_size", int32(1))
+		pipeline.SetUniform("palette_transition", float32(1.0))
+	}
+
+	graphics.DrawMeshInstanced(cells.mesh, cells.count, []graphics.InstanceBuffer{cells.instances}, []uint32{2})
+}
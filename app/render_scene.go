@@ -66,6 +66,7 @@ type meshDataInstanced struct {
 	modelMatrix []mgl32.Mat4
 	color 	    []mgl32.Vec4
 	count 		int32
+	material    MaterialSettings
 }
 
 // Slices for storing draw data.
@@ -165,6 +166,8 @@ func InitSceneRendering() {
 	meshEntities 		  = make([]meshData, 0, 100)
 	meshEntitiesInstanced = make([]meshDataInstanced, 0, 10)
 	meshEntitiesSceneUI   = make([]meshData, 0, 100)
+
+	initCellsRendering()
 }
 
 // RenderScene sends commands to draw meshes gathered from DrawMeshXXX calls.
@@ -185,8 +188,8 @@ func RenderScene(targetBuffer graphics.Framebuffer, sceneView SceneView, viewMat
 	pipelinePBR.Start()
 	pipelinePBR.SetUniform("projection_matrix", projectionMatrix)
 	pipelinePBR.SetUniform("view_matrix", viewMatrix)
-	pipelinePBR.SetUniform("roughness", float32(settings.Roughness))
-	pipelinePBR.SetUniform("reflectivity", float32(settings.Reflectivity))
+	pipelinePBR.SetUniform("roughness", float32(defaultCellSettings.Material.Roughness))
+	pipelinePBR.SetUniform("reflectivity", float32(defaultCellSettings.Material.Reflectivity))
 	pipelinePBR.SetUniform("direct_light_power", float32(settings.DirectLight))
 	pipelinePBR.SetUniform("ambient_light_power", float32(settings.AmbientLight))
 	
@@ -198,16 +201,31 @@ func RenderScene(targetBuffer graphics.Framebuffer, sceneView SceneView, viewMat
 	pipelinePBRInstanced.Start()
 	pipelinePBRInstanced.SetUniform("projection_matrix", projectionMatrix)
 	pipelinePBRInstanced.SetUniform("view_matrix", viewMatrix)
-	pipelinePBRInstanced.SetUniform("roughness", float32(settings.Roughness))
-	pipelinePBRInstanced.SetUniform("reflectivity", float32(settings.Reflectivity))
 	pipelinePBRInstanced.SetUniform("direct_light_power", float32(settings.DirectLight))
 	pipelinePBRInstanced.SetUniform("ambient_light_power", float32(settings.AmbientLight))
 
 	for _, meshEntity := range meshEntitiesInstanced {
+		// Every instanced mesh (cell layer) has its own material.
+		pipelinePBRInstanced.SetUniform("roughness", float32(meshEntity.material.Roughness))
+		pipelinePBRInstanced.SetUniform("reflectivity", float32(meshEntity.material.Reflectivity))
 		drawMeshInstanced(meshEntity.mesh, meshEntity.modelMatrix,
 						  meshEntity.color, meshEntity.count)
 	}
 
+	// Cells with transforms computed on GPU.
+	pipelinePBRCells.Start()
+	pipelinePBRCells.SetUniform("projection_matrix", projectionMatrix)
+	pipelinePBRCells.SetUniform("view_matrix", viewMatrix)
+	pipelinePBRCells.SetUniform("direct_light_power", float32(settings.DirectLight))
+	pipelinePBRCells.SetUniform("ambient_light_power", float32(settings.AmbientLight))
+
+	for i := range cellEntities {
+		material := cellEntities[i].settings.Material
+		pipelinePBRCells.SetUniform("roughness", float32(material.Roughness))
+		pipelinePBRCells.SetUniform("reflectivity", float32(material.Reflectivity))
+		drawCells(pipelinePBRCells, &cellEntities[i])
+	}
+
 	// Since color rendering was multisampled, we need to resolve into non-MS framebuffer
 	// for it to be used later as a texture.
 	graphics.BlitFramebufferAttachment(sceneView.bufferLightMS, sceneView.bufferLight, "direct", "direct")
@@ -237,6 +255,15 @@ func RenderScene(targetBuffer graphics.Framebuffer, sceneView SceneView, viewMat
 						  meshEntity.color, meshEntity.count)
 	}
 
+	// Cells with transforms computed on GPU.
+	pipelineGeometryCells.Start()
+	pipelineGeometryCells.SetUniform("projection_matrix", projectionMatrix)
+	pipelineGeometryCells.SetUniform("view_matrix", viewMatrix)
+
+	for i := range cellEntities {
+		drawCells(pipelineGeometryCells, &cellEntities[i])
+	}
+
 	// SSAO computation. 
 	graphics.SetFramebuffer(sceneView.bufferSSAO)
 	graphics.SetFramebufferViewport(sceneView.bufferSSAO)
@@ -320,6 +347,7 @@ func ResetScene() {
 	meshEntities 		  = meshEntities[:0]
 	meshEntitiesInstanced = meshEntitiesInstanced[:0]
 	meshEntitiesSceneUI   = meshEntitiesSceneUI[:0]
+	cellEntities          = cellEntities[:0]
 }
 
 // DrawMesh sets mesh to be drawn in scene next frame.
@@ -327,9 +355,10 @@ func DrawMesh(mesh graphics.Mesh, modelMatrix mgl32.Mat4, color mgl32.Vec4) {
 	meshEntities = append(meshEntities, meshData{mesh, modelMatrix, color})
 }
 
-// DrawMeshInstanced sets mesh to be drawn multiple times in scene next frame.
-func DrawMeshInstanced(mesh graphics.Mesh, modelMatrix []mgl32.Mat4, color []mgl32.Vec4, count int) {
-	meshEntitiesInstanced = append(meshEntitiesInstanced, meshDataInstanced{mesh, modelMatrix, color, int32(count)})
+// DrawMeshInstanced sets mesh to be drawn multiple times in scene next frame, all the instances
+// share the same material.
+func DrawMeshInstanced(mesh graphics.Mesh, modelMatrix []mgl32.Mat4, color []mgl32.Vec4, count int, material MaterialSettings) {
+	meshEntitiesInstanced = append(meshEntitiesInstanced, meshDataInstanced{mesh, modelMatrix, color, int32(count), material})
 }
 
 // DrawMeshSceneUI sets mesh to be drawn as in-scene UI next frame.
@@ -345,8 +374,8 @@ func drawMesh(pipeline graphics.Pipeline, mesh graphics.Mesh, modelMatrix mgl32.
 
 func drawMeshInstanced(mesh graphics.Mesh, modelMatrix []mgl32.Mat4,
 					   color []mgl32.Vec4, count int32) {
-	graphics.UpdateInstanceBuffer(instanceModelBuffer, int(count), modelMatrix)
-	graphics.UpdateInstanceBuffer(instanceColorBuffer, int(count), color)
+	graphics.UpdateInstanceBuffer(&instanceModelBuffer, int(count), modelMatrix)
+	graphics.UpdateInstanceBuffer(&instanceColorBuffer, int(count), color)
 	graphics.DrawMeshInstanced(mesh, count,
 							   []graphics.InstanceBuffer{instanceModelBuffer, instanceColorBuffer},
 							   []uint32{2, 6})
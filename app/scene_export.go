@@ -0,0 +1,296 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"../lib/geometry"
+)
+
+// Formats scenes can be exported in.
+const (
+	// Binary glTF 2.0.
+	SceneFormatGLTF = "gltf"
+	// Wavefront OBJ with materials in MTL file next to it.
+	SceneFormatOBJ = "obj"
+)
+
+// exportedMaterial is material of exported cells. Colors are quantized, so cells with similar colors
+// share materials.
+type exportedMaterial struct {
+	color        [3]uint8
+	roughness    float64
+	reflectivity float64
+}
+
+// exportedLayer holds data of a layer's cells needed for export.
+type exportedLayer struct {
+	mesh     geometry.MeshData
+	matrices []mgl32.Mat4
+}
+
+// exportedGroup is a group of cells of a single layer sharing the same material.
+// Their geometry is baked, every cell gets a transformed copy of the layer's mesh.
+type exportedGroup struct {
+	layer    int
+	material int
+	cells    []int
+	min, max mgl32.Vec3
+}
+
+// exportedScene holds cells of all layers of settings, grouped by materials.
+type exportedScene struct {
+	layers    []exportedLayer
+	materials []exportedMaterial
+	groups    []exportedGroup
+}
+
+// ExportScene writes cells of all layers of settings into EXPORTS_DIR in format, with the same
+// transforms and colors they're rendered with. Cells are generated from the layers' seeds, counts
+// of cells are clamped to maxCount. File is named after the preset, its path is returned.
+func ExportScene(settings AppSettings, format string, maxCount int) (string, error) {
+	if format != SceneFormatGLTF && format != SceneFormatOBJ {
+		return "", fmt.Errorf("unknown scene format %q, supported formats are %s and %s", format, SceneFormatGLTF, SceneFormatOBJ)
+	}
+	scene := getExportedScene(&settings, maxCount)
+
+	os.MkdirAll(EXPORTS_DIR, 0700)
+	name := getBundleFileName(settings.Metadata.Name)
+	var path string
+	var err error
+	if format == SceneFormatGLTF {
+		path = getUniquePath(EXPORTS_DIR, name, ".glb")
+		err = writeSceneFile(path, func(writer io.Writer) error {
+			return writeSceneGLB(writer, &scene)
+		})
+	} else {
+		path = getUniquePath(EXPORTS_DIR, name, ".obj")
+		materialsPath := strings.TrimSuffix(path, ".obj") + ".mtl"
+		err = writeSceneFile(materialsPath, func(writer io.Writer) error {
+			return writeSceneMTL(writer, &scene)
+		})
+		if err == nil {
+			err = writeSceneFile(path, func(writer io.Writer) error {
+				return writeSceneOBJ(writer, &scene, filepath.Base(materialsPath))
+			})
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeSceneFile creates file at path and writes it using write. Incomplete file is removed.
+func writeSceneFile(path string, write func(writer io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriterSize(file, 1 << 20)
+	err = write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func getExportedScene(settings *AppSettings, maxCount int) exportedScene {
+	var scene exportedScene
+	materials := make(map[exportedMaterial]int)
+	for layerIndex := range settings.Layers {
+		layerSettings := CopyCellSettings(settings.Layers[layerIndex])
+		layerSettings.Count = GetLayerCount(&layerSettings, maxCount)
+		cells := make([]Cell, layerSettings.Count)
+		GenerateCells(cells, layerSettings.Seed)
+		layer := exportedLayer{
+			GetCellShapeMeshData(&layerSettings.Shape),
+			GetCellModelMatrices(cells, layerSettings),
+		}
+		scene.layers = append(scene.layers, layer)
+
+		groups := make(map[int]int)
+		for i, color := range GetLayerColors(cells, &layerSettings) {
+			// Cells hidden to avoid overlaps have no volume, they're left out.
+			if layer.matrices[i].Mat3().Det() == 0 {
+				continue
+			}
+			material := exportedMaterial{roughness: layerSettings.Material.Roughness, reflectivity: layerSettings.Material.Reflectivity}
+			for channel := range material.color {
+				material.color[channel] = uint8(math.Round(float64(mgl32.Clamp(color[channel], 0, 1)) * 255))
+			}
+			materialIndex, ok := materials[material]
+			if !ok {
+				materialIndex = len(scene.materials)
+				materials[material] = materialIndex
+				scene.materials = append(scene.materials, material)
+			}
+			groupIndex, ok := groups[materialIndex]
+			if !ok {
+				groupIndex = len(scene.groups)
+				groups[materialIndex] = groupIndex
+				scene.groups = append(scene.groups, exportedGroup{layer: layerIndex, material: materialIndex})
+			}
+			scene.groups[groupIndex].cells = append(scene.groups[groupIndex].cells, i)
+		}
+	}
+
+	for i := range scene.groups {
+		group := &scene.groups[i]
+		first := true
+		scene.forEachVertex(group, func(position, normal mgl32.Vec3) {
+			if first {
+				group.min, group.max = position, position
+				first = false
+			}
+			for axis := range position {
+				group.min[axis] = float32(math.Min(float64(group.min[axis]), float64(position[axis])))
+				group.max[axis] = float32(math.Max(float64(group.max[axis]), float64(position[axis])))
+			}
+		})
+	}
+	return scene
+}
+
+// getVertexCount returns number of vertices of group's baked geometry.
+func (scene *exportedScene) getVertexCount(group *exportedGroup) int {
+	return len(group.cells) * scene.layers[group.layer].mesh.VertexCount()
+}
+
+// getIndexCount returns number of indices of group's baked geometry.
+func (scene *exportedScene) getIndexCount(group *exportedGroup) int {
+	return len(group.cells) * len(scene.layers[group.layer].mesh.Indices)
+}
+
+// forEachVertex calls visit with world space position and normal of every vertex of group.
+func (scene *exportedScene) forEachVertex(group *exportedGroup, visit func(position, normal mgl32.Vec3)) {
+	layer := &scene.layers[group.layer]
+	for _, cell := range group.cells {
+		matrix := layer.matrices[cell]
+		normalMatrix := matrix.Mat3().Inv().Transpose()
+		for i := 0; i < layer.mesh.VertexCount(); i++ {
+			position := matrix.Mul4x1(layer.mesh.Position(uint32(i)).Vec4(1.0)).Vec3()
+			normal := normalMatrix.Mul3x1(layer.mesh.Normal(uint32(i)))
+			if normal.Len() > 0 {
+				normal = normal.Normalize()
+			}
+			visit(position, normal)
+		}
+	}
+}
+
+// forEachTriangle calls visit with indices of every triangle of group, relative to the group's first vertex.
+// Triangles are wound counter-clockwise when seen from outside, as both glTF and OBJ expect.
+func (scene *exportedScene) forEachTriangle(group *exportedGroup, visit func(a, b, c uint32)) {
+	layer := &scene.layers[group.layer]
+	vertexCount := uint32(layer.mesh.VertexCount())
+	indices := layer.mesh.Indices
+	for i, cell := range group.cells {
+		offset := uint32(i) * vertexCount
+		// Mirroring transforms flip winding of triangles.
+		mirrored := layer.matrices[cell].Mat3().Det() < 0
+		for j := 0; j + 2 < len(indices); j += 3 {
+			a, b, c := indices[j] + offset, indices[j + 1] + offset, indices[j + 2] + offset
+			if mirrored {
+				visit(a, b, c)
+			} else {
+				visit(a, c, b)
+			}
+		}
+	}
+}
+
+// getColor returns color of material in [0, 1] range.
+func (material *exportedMaterial) getColor() mgl32.Vec3 {
+	return mgl32.Vec3{float32(material.color[0]), float32(material.color[1]), float32(material.color[2])}.Mul(1.0 / 255.0)
+}
+
+func getExportedMaterialName(index int) string {
+	return "material_" + strconv.Itoa(index + 1)
+}
+
+func getExportedLayerName(index int) string {
+	return "layer_" + strconv.Itoa(index + 1)
+}
+
+func writeSceneMTL(writer io.Writer, scene *exportedScene) error {
+	fmt.Fprintln(writer, "# iris scene materials")
+	for i := range scene.materials {
+		material := &scene.materials[i]
+		color := material.getColor()
+		fmt.Fprintf(writer, "\nnewmtl %s\n", getExportedMaterialName(i))
+		fmt.Fprintf(writer, "Kd %.4f %.4f %.4f\n", color[0], color[1], color[2])
+		fmt.Fprintf(writer, "Ks %.4f %.4f %.4f\n", material.reflectivity, material.reflectivity, material.reflectivity)
+		// Shininess for viewers without PBR, roughness for the ones supporting PBR extension of MTL.
+		fmt.Fprintf(writer, "Ns %.1f\n", math.Pow(1.0 - material.roughness, 2.0) * 1000.0)
+		fmt.Fprintf(writer, "Pr %.4f\n", material.roughness)
+		_, err := fmt.Fprintln(writer, "illum 2")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSceneOBJ(writer io.Writer, scene *exportedScene, materialsName string) error {
+	fmt.Fprintln(writer, "# iris scene")
+	fmt.Fprintf(writer, "mtllib %s\n", materialsName)
+	line := make([]byte, 0, 128)
+	writeVector := func(prefix string, vector mgl32.Vec3) {
+		line = append(line[:0], prefix...)
+		for _, value := range vector {
+			line = append(line, ' ')
+			line = strconv.AppendFloat(line, float64(value), 'f', 6, 32)
+		}
+		line = append(line, '\n')
+		writer.Write(line)
+	}
+
+	// OBJ indices are global and start at 1.
+	firstVertex := uint32(1)
+	layer := -1
+	for i := range scene.groups {
+		group := &scene.groups[i]
+		if group.layer != layer {
+			layer = group.layer
+			fmt.Fprintf(writer, "o %s\n", getExportedLayerName(layer))
+		}
+		fmt.Fprintf(writer, "usemtl %s\n", getExportedMaterialName(group.material))
+		scene.forEachVertex(group, func(position, normal mgl32.Vec3) {
+			writeVector("v", position)
+		})
+		scene.forEachVertex(group, func(position, normal mgl32.Vec3) {
+			writeVector("vn", normal)
+		})
+		scene.forEachTriangle(group, func(a, b, c uint32) {
+			line = append(line[:0], 'f')
+			for _, index := range []uint32{a, b, c} {
+				index += firstVertex
+				line = append(line, ' ')
+				line = strconv.AppendUint(line, uint64(index), 10)
+				line = append(line, '/', '/')
+				line = strconv.AppendUint(line, uint64(index), 10)
+			}
+			line = append(line, '\n')
+			writer.Write(line)
+		})
+		firstVertex += uint32(scene.getVertexCount(group))
+	}
+	_, err := fmt.Fprintln(writer, "# end")
+	return err
+}
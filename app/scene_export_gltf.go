@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Constants of binary glTF container and glTF enums.
+const (
+	glbMagic          = 0x46546C67
+	glbVersion        = 2
+	glbChunkJSON      = 0x4E4F534A
+	glbChunkBinary    = 0x004E4942
+	gltfFloat         = 5126
+	gltfUnsignedInt   = 5125
+	gltfArrayBuffer   = 34962
+	gltfElementBuffer = 34963
+)
+
+// Types below describe the subset of glTF 2.0 used by exported scenes.
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+}
+
+type gltfMaterial struct {
+	Name                 string                   `json:"name"`
+	PBRMetallicRoughness gltfPBRMetallicRoughness `json:"pbrMetallicRoughness"`
+}
+
+type gltfPBRMetallicRoughness struct {
+	BaseColorFactor [4]float32 `json:"baseColorFactor"`
+	MetallicFactor  float64    `json:"metallicFactor"`
+	RoughnessFactor float64    `json:"roughnessFactor"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfBuffer struct {
+	ByteLength int `json:"byteLength"`
+}
+
+// writeSceneGLB writes scene as binary glTF. Every layer is a node with a mesh, which has
+// a primitive for every group of the layer's cells.
+func writeSceneGLB(writer io.Writer, scene *exportedScene) error {
+	document := gltfDocument{
+		Asset:  gltfAsset{"2.0", "iris"},
+		Scenes: []gltfScene{{}},
+	}
+	for i := range scene.materials {
+		material := &scene.materials[i]
+		color := material.getColor()
+		document.Materials = append(document.Materials, gltfMaterial{
+			getExportedMaterialName(i),
+			gltfPBRMetallicRoughness{[4]float32{color[0], color[1], color[2], 1.0}, 0.0, material.roughness},
+		})
+	}
+
+	// Binary buffer holds positions, normals and indices of groups one after another.
+	byteLength := 0
+	addBufferView := func(length, target int) int {
+		document.BufferViews = append(document.BufferViews, gltfBufferView{0, byteLength, length, target})
+		byteLength += length
+		return len(document.BufferViews) - 1
+	}
+	addAccessor := func(accessor gltfAccessor) int {
+		document.Accessors = append(document.Accessors, accessor)
+		return len(document.Accessors) - 1
+	}
+	layer := -1
+	for i := range scene.groups {
+		group := &scene.groups[i]
+		if group.layer != layer {
+			layer = group.layer
+			document.Scenes[0].Nodes = append(document.Scenes[0].Nodes, len(document.Nodes))
+			document.Nodes = append(document.Nodes, gltfNode{getExportedLayerName(group.layer), len(document.Meshes)})
+			document.Meshes = append(document.Meshes, gltfMesh{Name: getExportedLayerName(group.layer)})
+		}
+		vertexCount, indexCount := scene.getVertexCount(group), scene.getIndexCount(group)
+		position := addAccessor(gltfAccessor{addBufferView(vertexCount * 12, gltfArrayBuffer), gltfFloat, vertexCount, "VEC3", group.min[:], group.max[:]})
+		normal := addAccessor(gltfAccessor{addBufferView(vertexCount * 12, gltfArrayBuffer), gltfFloat, vertexCount, "VEC3", nil, nil})
+		indices := addAccessor(gltfAccessor{addBufferView(indexCount * 4, gltfElementBuffer), gltfUnsignedInt, indexCount, "SCALAR", nil, nil})
+		mesh := &document.Meshes[len(document.Meshes) - 1]
+		mesh.Primitives = append(mesh.Primitives, gltfPrimitive{
+			map[string]int{"POSITION": position, "NORMAL": normal},
+			indices,
+			group.material,
+		})
+	}
+	document.Buffers = []gltfBuffer{{byteLength}}
+
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	// Chunks are padded to 4 bytes, JSON with spaces.
+	for len(documentJSON) % 4 != 0 {
+		documentJSON = append(documentJSON, ' ')
+	}
+	binaryPadding := (4 - byteLength % 4) % 4
+	totalLength := 12 + 8 + len(documentJSON) + 8 + byteLength + binaryPadding
+	if int64(totalLength) > math.MaxUint32 {
+		return errors.New("scene is too large for glTF, use fewer cells or simpler mesh")
+	}
+
+	binary.Write(writer, binary.LittleEndian, []uint32{glbMagic, glbVersion, uint32(totalLength)})
+	binary.Write(writer, binary.LittleEndian, []uint32{uint32(len(documentJSON)), glbChunkJSON})
+	writer.Write(documentJSON)
+	binary.Write(writer, binary.LittleEndian, []uint32{uint32(byteLength + binaryPadding), glbChunkBinary})
+
+	var chunk bytes.Buffer
+	var value [4]byte
+	writeFloat := func(x float32) {
+		binary.LittleEndian.PutUint32(value[:], math.Float32bits(x))
+		chunk.Write(value[:])
+	}
+	// Data is written in small chunks, so the whole buffer is never in memory.
+	flush := func() {
+		if chunk.Len() > 1 << 16 {
+			writer.Write(chunk.Bytes())
+			chunk.Reset()
+		}
+	}
+	for i := range scene.groups {
+		group := &scene.groups[i]
+		scene.forEachVertex(group, func(position, normal mgl32.Vec3) {
+			writeFloat(position[0])
+			writeFloat(position[1])
+			writeFloat(position[2])
+			flush()
+		})
+		scene.forEachVertex(group, func(position, normal mgl32.Vec3) {
+			writeFloat(normal[0])
+			writeFloat(normal[1])
+			writeFloat(normal[2])
+			flush()
+		})
+		scene.forEachTriangle(group, func(a, b, c uint32) {
+			for _, index := range []uint32{a, b, c} {
+				binary.LittleEndian.PutUint32(value[:], index)
+				chunk.Write(value[:])
+			}
+			flush()
+		})
+	}
+	chunk.Write(make([]byte, binaryPadding))
+	_, err = writer.Write(chunk.Bytes())
+	return err
+}
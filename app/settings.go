@@ -1,13 +1,16 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"math"
 	"os"
-	"sort"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"time"
 	
 	"github.com/go-gl/mathgl/mgl32"
 
@@ -19,6 +22,88 @@ type CellSettings struct {
 	HeightRatio            float64
 	Count				   int
 	Colors        		   []mgl32.Vec4
+	Seed                   int64
+	Distribution           DistributionSettings
+	Material               MaterialSettings
+	Scale                  ScaleSettings
+	Shape                  ShapeSettings
+	Coloring               ColoringSettings
+	Points                 PointsSettings
+	Overlap                OverlapSettings
+	Symmetry               SymmetrySettings
+	Animation              AnimationSettings
+	// Changes of individual cells, see CellOverride.
+	Overrides              []CellOverride
+}
+
+// OverlapSettings control whether cells may intersect each other. Intersecting faces flicker,
+// so cells can be shrunk or hidden to keep them apart.
+type OverlapSettings struct {
+	Avoid bool
+	// Minimum distance between bounding boxes of cells, used only when overlaps are avoided.
+	MinGap float64
+}
+
+// SymmetrySettings replicate cells of a layer around the y axis and across planes through the origin.
+// Every generated cell gets Fold rotated copies, all of them mirrored by every combination of the
+// selected planes. Copies count towards the layer's cell count.
+type SymmetrySettings struct {
+	// Number of copies rotated around the y axis, 1 means no rotational symmetry.
+	Fold int
+	// Mirroring across planes perpendicular to x, y and z axes.
+	MirrorX, MirrorY, MirrorZ bool
+}
+
+// AnimationSettings describe motions of cells driven by animation clock, see SetAnimationTime.
+// Speeds are in cycles per second, motions with zero speed are off.
+type AnimationSettings struct {
+	// Cells orbit around the y axis, amplitude is how much their speeds differ, relative to the speed.
+	Orbit AnimationMotion
+	// Cells grow and shrink, amplitude is relative to their size.
+	Breathe AnimationMotion
+	// Cells move to and from the center in waves going outwards, amplitude is the distance.
+	Wave AnimationMotion
+	// Cells rotate around their vertical axes, amplitude is how much their speeds differ, relative to the speed.
+	Spin AnimationMotion
+}
+
+type AnimationMotion struct {
+	Speed, Amplitude float64
+}
+
+// PointsSettings select point-cloud file cells are placed by, instead of their distribution
+// and dimensions. See ExportCellPoints for its format.
+type PointsSettings struct {
+	// Path of the file, cells are placed as usual when it's empty.
+	File string
+	// Only cells of this layer of the file are used, all of them are used when it's 0.
+	Layer int
+}
+
+// ShapeSettings describe mesh every cell of a layer is drawn with.
+type ShapeSettings struct {
+	Name string
+	// Radius of rounded edges, relative to size of the cell. Only boxes have it.
+	Bevel float64
+	// Path of OBJ or PLY file cells are drawn with instead of the shape with Name.
+	// Relative paths are relative to the working directory, like shaders and fonts.
+	Mesh string
+}
+
+// ScaleSettings describe dimensions of cells. Width and depth of cells are spread between
+// their minimum and maximum by the distribution, height is their average multiplied by HeightRatio.
+type ScaleSettings struct {
+	Distribution       string
+	WidthMin, WidthMax float64
+	DepthMin, DepthMax float64
+	// Whether cells are as deep as they are wide, depth range is ignored then.
+	Uniform bool
+}
+
+// MaterialSettings describe surface of cells.
+type MaterialSettings struct {
+	Roughness    float64
+	Reflectivity float64
 }
 
 type CameraSettings struct {
@@ -26,7 +111,9 @@ type CameraSettings struct {
 }
 
 type AppSettings struct {
-	Cells         CellSettings
+	Version       int
+	Metadata      PresetMetadata
+	Layers        []CellSettings
 	Rendering     RenderingSettings
 	Camera        CameraSettings
 	id 			  int
@@ -36,9 +123,6 @@ type RenderingSettings struct {
 	DirectLight  float64
 	AmbientLight float64
 
-	Roughness    float64
-	Reflectivity float64
-
 	SSAORadius   float64
 	SSAORange    float64
 	SSAOBoundary float64
@@ -46,38 +130,86 @@ type RenderingSettings struct {
 	MinWhite float64
 }
 
+// Maximum number of cell layers in a scene.
+const MaxLayers = 8
+
 func copySettings(settings *AppSettings) AppSettings {
 	newSettings := AppSettings{}
-	newSettings.Cells = settings.Cells
+	newSettings.Metadata = settings.Metadata
+	newSettings.Metadata.Tags = make([]string, len(settings.Metadata.Tags))
+	copy(newSettings.Metadata.Tags, settings.Metadata.Tags)
+	newSettings.Layers = make([]CellSettings, len(settings.Layers))
+	for i := range settings.Layers {
+		newSettings.Layers[i] = CopyCellSettings(settings.Layers[i])
+	}
 	newSettings.Rendering = settings.Rendering
 	newSettings.Camera = settings.Camera
-	newSettings.Cells.Colors = make([]mgl32.Vec4, len(settings.Cells.Colors))
-	copy(newSettings.Cells.Colors, settings.Cells.Colors)
 	return newSettings
 }
 
-var defaultSettings = AppSettings{
-	Cells: CellSettings{
-		PolarStd: 0.02, PolarMean: math.Pi / 2.0,
-		RadiusMin: 3.0, RadiusMax: 15.0,
-		HeightRatio: 1.0,
-		Count: 5000,
-		Colors: []mgl32.Vec4{
-			mgl32.Vec4{24 / 255.0, 193 / 255.0, 236 / 255.0, 1.0},
-			mgl32.Vec4{0 / 255.0, 185 / 255.0, 121 / 255.0, 1.0},
-			mgl32.Vec4{236 / 255.0, 24 / 255.0, 97 / 255.0, 1.0},
-			mgl32.Vec4{33 / 255.0, 73 / 255.0, 83 / 255.0, 1.0},
-			mgl32.Vec4{194 / 255.0, 55 / 255.0, 48 / 255.0, 1.0},
-		},
+// CopyCellSettings returns deep copy of settings of a cell layer.
+func CopyCellSettings(settings CellSettings) CellSettings {
+	colors := make([]mgl32.Vec4, len(settings.Colors))
+	copy(colors, settings.Colors)
+	settings.Colors = colors
+	settings.Distribution = copyDistributionSettings(settings.Distribution)
+	settings.Coloring = copyColoringSettings(settings.Coloring)
+	settings.Overrides = copyCellOverrides(settings.Overrides)
+	return settings
+}
+
+// UnmarshalJSON parses settings of a layer, values missing from data are taken from default settings.
+func (settings *CellSettings) UnmarshalJSON(data []byte) error {
+	// Type without methods, so parsing doesn't end up calling this again.
+	type cellSettings CellSettings
+	layer := cellSettings(CopyCellSettings(defaultCellSettings))
+	err := json.Unmarshal(data, &layer)
+	if err != nil {
+		return err
+	}
+	*settings = CellSettings(layer)
+	return nil
+}
+
+// Dimensions of cells in default settings, the same as before they became configurable.
+var defaultScaleSettings = ScaleSettings{
+	Distribution: ScaleDistributionSquared,
+	WidthMin: 0.11, WidthMax: 2.75,
+	DepthMin: 0.16, DepthMax: 4.0,
+}
+
+// Settings of a single layer in default settings.
+var defaultCellSettings = CellSettings{
+	PolarStd: 0.02, PolarMean: math.Pi / 2.0,
+	RadiusMin: 3.0, RadiusMax: 15.0,
+	HeightRatio: 1.0,
+	Count: 5000,
+	Seed: defaultCellsSeed,
+	Distribution: DistributionSettings{Name: DefaultDistributionName},
+	Material: MaterialSettings{
+		Roughness:    1.0,
+		Reflectivity: 0.05,
 	},
+	Scale: defaultScaleSettings,
+	Shape: ShapeSettings{Name: DefaultCellShape},
+	Symmetry: SymmetrySettings{Fold: 1},
+	Coloring: ColoringSettings{Name: DefaultColoringName},
+	Colors: []mgl32.Vec4{
+		mgl32.Vec4{24 / 255.0, 193 / 255.0, 236 / 255.0, 1.0},
+		mgl32.Vec4{0 / 255.0, 185 / 255.0, 121 / 255.0, 1.0},
+		mgl32.Vec4{236 / 255.0, 24 / 255.0, 97 / 255.0, 1.0},
+		mgl32.Vec4{33 / 255.0, 73 / 255.0, 83 / 255.0, 1.0},
+		mgl32.Vec4{194 / 255.0, 55 / 255.0, 48 / 255.0, 1.0},
+	},
+}
+
+var defaultSettings = AppSettings{
+	Layers: []CellSettings{defaultCellSettings},
 
 	Rendering: RenderingSettings{
 		DirectLight:  0.5,
 		AmbientLight: 0.75,
 
-		Roughness:    1.0,
-		Reflectivity: 0.05,
-
 		SSAORadius:   0.5,
 		SSAORange:    3.0,
 		SSAOBoundary: 1.0,
@@ -88,69 +220,211 @@ var defaultSettings = AppSettings{
 	Camera: CameraSettings{100.0, 0.0, 0.0, 0.0},
 }
 
-func loadSingleSettings(path string) AppSettings {
+// decodeSettings parses serialized settings, migrating them to the current version if necessary.
+// Missing values are filled in from default settings. It returns whether migration was applied.
+func decodeSettings(serializedSettings []byte) (AppSettings, bool, error) {
 	settings := copySettings(&defaultSettings)
-	serializedSettings, err := ioutil.ReadFile(path)
+	serializedSettings, migrated, err := migrateSettings(serializedSettings)
 	if err != nil {
-		return settings
+		return settings, false, err
 	}
 	err = json.Unmarshal(serializedSettings, &settings)
 	if err != nil {
-		panic(err)
+		return settings, false, err
 	}
-	return settings
+	err = validateSettings(&settings)
+	if err != nil {
+		return settings, false, err
+	}
+	return settings, migrated, nil
 }
 
-func saveSingleSettings(path string, settings AppSettings) {
-	serializedSettings, err := json.Marshal(settings)
-	if err != nil {
-		panic(err)
+// encodeSettings serializes settings, stamped with the current version.
+func encodeSettings(settings AppSettings) ([]byte, error) {
+	settings.Version = currentSettingsVersion
+	return json.Marshal(settings)
+}
+
+// validateSettings checks for values which would make settings unusable and clamps values out of range.
+func validateSettings(settings *AppSettings) error {
+	if len(settings.Layers) == 0 {
+		return errors.New("settings have no layers")
+	} else if len(settings.Layers) > MaxLayers {
+		return fmt.Errorf("settings have %d layers, at most %d are supported", len(settings.Layers), MaxLayers)
 	}
-	err = ioutil.WriteFile(path, serializedSettings, 0644)
+	for i, layer := range settings.Layers {
+		if len(layer.Colors) < MinPaletteSize {
+			return fmt.Errorf("layer %d has no colors", i + 1)
+		} else if len(layer.Colors) > MaxPaletteSize {
+			return fmt.Errorf("layer %d has %d colors, at most %d are supported", i + 1, len(layer.Colors), MaxPaletteSize)
+		}
+	}
+	settings.Metadata.Rating = int(math.Max(0, math.Min(float64(settings.Metadata.Rating), MaxRating)))
+	return nil
+}
+
+// loadSingleSettings loads settings from file at path. If the file doesn't exist,
+// default settings are returned. Settings which cannot be parsed are reported as error.
+func loadSingleSettings(path string) (AppSettings, error) {
+	serializedSettings, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return copySettings(&defaultSettings), nil
+	} else if err != nil {
+		return copySettings(&defaultSettings), err
+	}
+	settings, migrated, err := decodeSettings(serializedSettings)
 	if err != nil {
-		panic(err)
+		return settings, err
+	}
+
+	// Rewrite migrated settings, so the file doesn't have to be migrated again on the next load.
+	// Failing to do so isn't fatal, the file will be migrated again next time.
+	if migrated {
+		err = saveSingleSettings(path, settings)
+		if err != nil {
+			log.Printf("settings: couldn't rewrite migrated %s: %v", path, err)
+		}
 	}
+	return settings, nil
 }
 
+func saveSingleSettings(path string, settings AppSettings) error {
+	serializedSettings, err := encodeSettings(settings)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, serializedSettings, 0644)
+}
 
 var SAVES_DIR = "saves"
-var maxSaveNum int
+var ACTIVE_SETTINGS_PATH = "settings"
 
+// Store of saved presets and its in-memory copy. Position of a preset in settingsList
+// is the index used by GetSettings/DeleteSettings, AppSettings.id is its id in the store.
+var settingsStore SettingsStore
 var settingsList []AppSettings
-func LoadSettings() (AppSettings, int) {
-	os.Mkdir(SAVES_DIR, 0700)
-	files, err := ioutil.ReadDir(SAVES_DIR)
+
+// LoadSettings loads all the presets from store and the active settings. Presets which cannot be
+// loaded are skipped (and quarantined, if store supports it). Returned error is not fatal,
+// it describes what was skipped - returned settings are always usable.
+func LoadSettings(store SettingsStore) (AppSettings, int, error) {
+	settingsStore = store
+	settingsList = make([]AppSettings, 0)
+
+	corruptCount := 0
+	ids, listErr := settingsStore.List()
+	for _, id := range ids {
+		loadedSettings, err := getStoredSettings(id)
+		if err != nil {
+			corruptCount++
+			continue
+		}
+		settingsList = append(settingsList, loadedSettings)
+	}
+
+	activeSettings, err := loadSingleSettings(ACTIVE_SETTINGS_PATH)
 	if err != nil {
-		panic(err)
+		log.Printf("settings: %s is corrupt: %v", ACTIVE_SETTINGS_PATH, err)
+		// It's kept next to the file, the store of presets can be anywhere, even in an archive.
+		quarantineFile(ACTIVE_SETTINGS_PATH, filepath.Join(filepath.Dir(ACTIVE_SETTINGS_PATH), corruptDirName))
+		corruptCount++
+		activeSettings = copySettings(&defaultSettings)
 	}
-	
-	settingsList = make([]AppSettings, 0)
-	
-	settingsMap := make(map[int]AppSettings, 0)
-	settingsNameList := make([]int, 0)
-	for _, file := range files {
-		fileNameParts := strings.Split(file.Name(), "_")
-		fileNumString := "0"
-		if len(fileNameParts) > 1 {
-			fileNumString = fileNameParts[1]
+
+	if listErr != nil {
+		return activeSettings, len(settingsList), fmt.Errorf("couldn't read saves: %v", listErr)
+	} else if corruptCount > 0 {
+		return activeSettings, len(settingsList), fmt.Errorf("%d corrupt saves skipped", corruptCount)
+	}
+	return activeSettings, len(settingsList), nil
+}
+
+// getStoredSettings gets preset from store, quarantining it if it cannot be loaded.
+func getStoredSettings(id int) (AppSettings, error) {
+	settings, err := settingsStore.Get(id)
+	if err != nil {
+		log.Printf("settings: preset %d is corrupt: %v", id, err)
+		if quarantiner, ok := settingsStore.(settingsQuarantiner); ok {
+			quarantiner.Quarantine(id)
+		}
+		return settings, err
+	}
+	settings.id = id
+	return settings, nil
+}
+
+// SyncSettings reloads presets from store, picking up changes made outside of this process.
+// It returns indices of removed presets (in descending order, so they can be removed one
+// by one), indices of presets rewritten in place (after the removal) and number of presets
+// appended at the end of the list.
+func SyncSettings() ([]int, []int, int, error) {
+	ids, err := settingsStore.List()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	storedIDs := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		storedIDs[id] = true
+	}
+
+	// Remove presets which are no longer in the store.
+	removed := make([]int, 0)
+	knownIDs := make(map[int]bool, len(settingsList))
+	for i := len(settingsList) - 1; i >= 0; i-- {
+		id := settingsList[i].id
+		if !storedIDs[id] {
+			settingsList = append(settingsList[:i], settingsList[i + 1:]...)
+			removed = append(removed, i)
+		} else {
+			knownIDs[id] = true
+		}
+	}
+
+	// Reload presets which were rewritten, e.g. metadata edited by another instance.
+	changed := make([]int, 0)
+	for i := range settingsList {
+		storedSettings, err := settingsStore.Get(settingsList[i].id)
+		if err != nil || isSameStoredSettings(&settingsList[i], &storedSettings) {
+			continue
 		}
-		fileNum, _ := strconv.Atoi(fileNumString)
-		
-		if maxSaveNum < fileNum {
-			maxSaveNum = fileNum
+		storedSettings.id = settingsList[i].id
+		settingsList[i] = storedSettings
+		changed = append(changed, i)
+	}
+
+	// Append presets which were added to the store.
+	added := 0
+	for _, id := range ids {
+		if knownIDs[id] {
+			continue
 		}
-		loadedSettings := loadSingleSettings(SAVES_DIR + "/" + file.Name())
-		loadedSettings.id = fileNum
-		settingsMap[fileNum] = loadedSettings
-		settingsNameList = append(settingsNameList, fileNum)
+		loadedSettings, err := getStoredSettings(id)
+		if err != nil {
+			continue
+		}
+		settingsList = append(settingsList, loadedSettings)
+		added++
+	}
+	return removed, changed, added, nil
+}
+
+// isSameStoredSettings reports whether settings a and b would be stored the same.
+func isSameStoredSettings(a, b *AppSettings) bool {
+	first, err := encodeSettings(*a)
+	if err != nil {
+		return false
 	}
-	sort.Ints(settingsNameList)
-	for _, settingName := range settingsNameList {
-		settingsList = append(settingsList, settingsMap[settingName])
+	second, err := encodeSettings(*b)
+	if err != nil {
+		return false
 	}
+	return bytes.Equal(first, second)
+}
 
-	activeSettings := loadSingleSettings("settings")
-	return activeSettings, len(settingsList)
+// WatchSettings returns channel signalling that presets in store might have changed
+// and function which stops watching.
+func WatchSettings() (<-chan struct{}, func()) {
+	return settingsStore.Watch()
 }
 
 func GetSettings(index int) AppSettings {
@@ -158,26 +432,57 @@ func GetSettings(index int) AppSettings {
 	return settings
 }
 
-func SaveSettings(settings AppSettings) int {
+// SaveSettings saves settings as a new preset and returns the number of saved presets.
+// If the settings couldn't be written, they're not added to the presets.
+func SaveSettings(settings AppSettings) (int, error) {
+	settings.Metadata.Created = time.Now()
+	settings.Metadata.Modified = settings.Metadata.Created
+	id, err := settingsStore.Save(settings)
+	if err != nil {
+		return len(settingsList), err
+	}
+
 	newSettings := copySettings(&settings)
+	newSettings.id = id
 	settingsList = append(settingsList, newSettings)
-
-	maxSaveNum += 1
-	settingsName := "settings_" + strconv.Itoa(maxSaveNum)
-	path := SAVES_DIR + "/" + settingsName
-	saveSingleSettings(path, settings)
-	return len(settingsList)
+	return len(settingsList), nil
 }
 
-func SaveActiveSettings(settings AppSettings) {
-	saveSingleSettings("settings", settings)
+func SaveActiveSettings(settings AppSettings) error {
+	return saveSingleSettings(ACTIVE_SETTINGS_PATH, settings)
 }
 
-func DeleteSettings(index int) int {
-	filePath := SAVES_DIR + "/settings_" + strconv.Itoa(settingsList[index].id)
-	os.Remove(filePath)
-	settingsList = append(settingsList[:index], settingsList[index + 1:]...)
-	return len(settingsList)
+// RestoreSettings saves previously deleted preset back into store, keeping its metadata.
+// It returns the number of saved presets, restored preset is the last one.
+func RestoreSettings(settings AppSettings) (int, error) {
+	id, err := settingsStore.Save(settings)
+	if err != nil {
+		return len(settingsList), err
+	}
+
+	newSettings := copySettings(&settings)
+	newSettings.id = id
+	settingsList = append(settingsList, newSettings)
+	return len(settingsList), nil
 }
 
+// getSettingsIndex returns index of preset with id, or -1 if there's no such preset.
+func getSettingsIndex(id int) int {
+	for i := range settingsList {
+		if settingsList[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
 
+// DeleteSettings deletes preset at index and returns the number of saved presets.
+// If the preset couldn't be deleted from store, it is kept.
+func DeleteSettings(index int) (int, error) {
+	err := settingsStore.Delete(settingsList[index].id)
+	if err != nil {
+		return len(settingsList), err
+	}
+	settingsList = append(settingsList[:index], settingsList[index + 1:]...)
+	return len(settingsList), nil
+}
@@ -3,6 +3,8 @@ package app
 import (
 	"os"
 	"image"
+	"strings"
+	"unicode/utf8"
 	"github.com/go-gl/mathgl/mgl32"
 
 	"../lib/font"
@@ -15,9 +17,12 @@ type SettingsBar struct {
 	ContentY 		   FloatParameter
 	Color    		   ColorParameter
 	SaveButtonColor	   ColorParameter
+	SortButtonColor	   ColorParameter
 	DeleteButtonColors []ColorParameter
 	SettingsColors     []ColorParameter
 	SettingsTextures   []graphics.Texture
+	SettingsMetadata   []PresetMetadata
+	SortKey			   SettingsSortKey
 	Hidden			   bool
 
 	height		       float64
@@ -31,6 +36,7 @@ const (
 	SAVE   = iota
 	DELETE = iota
 	SELECT = iota
+	SORT   = iota
 )
 
 const layerBG = 2
@@ -60,6 +66,14 @@ var   saveButtonColorHover   = mgl32.Vec4{0, 1, 0.5, 1.0}
 var   saveTextColor  		 = mgl32.Vec4{0, 0, 0, 0.6}
 const saveButtonHeight 		 = 50.0
 
+var   sortButtonColor 	     = mgl32.Vec4{1, 1, 1, 0.4}
+var   sortButtonColorHover   = mgl32.Vec4{1, 1, 1, 0.8}
+const sortButtonWidth 		 = 220.0
+
+var   metadataBgColor 		 = mgl32.Vec4{0, 0, 0, 0.6}
+var   metadataTextColor 	 = mgl32.Vec4{1, 1, 1, 0.9}
+const metadataDateFormat 	 = "2006-01-02 15:04"
+
 func GetSettingsBar(font font.Font, height float64) SettingsBar {
 	var settingsBar SettingsBar
 
@@ -67,6 +81,7 @@ func GetSettingsBar(font font.Font, height float64) SettingsBar {
 	settingsBar.ContentY 		   = FloatParameter{0, 0}
 	settingsBar.Color   		   = ColorParameter{settingsBarColor, settingsBarColor}
 	settingsBar.SaveButtonColor    = ColorParameter{saveButtonColor, saveButtonColor}
+	settingsBar.SortButtonColor    = ColorParameter{sortButtonColor, sortButtonColor}
 	settingsBar.DeleteButtonColors = make([]ColorParameter, 0)
 	settingsBar.SettingsColors     = make([]ColorParameter, 0)
 	settingsBar.Hidden 			   = true
@@ -91,10 +106,11 @@ func GetSettingsBar(font font.Font, height float64) SettingsBar {
 	return settingsBar
 }
 
-func (settingsBar *SettingsBar) AddSettings(texture graphics.Texture) {
+func (settingsBar *SettingsBar) AddSettings(texture graphics.Texture, metadata PresetMetadata) {
 	settingsBar.DeleteButtonColors = append(settingsBar.DeleteButtonColors, ColorParameter{settingsDeleteButtonColorInactive, settingsDeleteButtonColorInactive})
 	settingsBar.SettingsColors     = append(settingsBar.SettingsColors, ColorParameter{settingsColor, settingsColor})
 	settingsBar.SettingsTextures   = append(settingsBar.SettingsTextures, texture)
+	settingsBar.SettingsMetadata   = append(settingsBar.SettingsMetadata, metadata)
 }
 
 func (settingsBar *SettingsBar) RemoveSettings(index int) {
@@ -102,6 +118,38 @@ func (settingsBar *SettingsBar) RemoveSettings(index int) {
 	settingsBar.SettingsTextures   = append(settingsBar.SettingsTextures[:index], settingsBar.SettingsTextures[index + 1:]...)
 	settingsBar.SettingsColors     = append(settingsBar.SettingsColors[:index], settingsBar.SettingsColors[index + 1:]...)
 	settingsBar.DeleteButtonColors = append(settingsBar.DeleteButtonColors[:index], settingsBar.DeleteButtonColors[index + 1:]...)
+	settingsBar.SettingsMetadata   = append(settingsBar.SettingsMetadata[:index], settingsBar.SettingsMetadata[index + 1:]...)
+}
+
+// SetSettingsTexture replaces thumbnail of settings at index, the previous one is released.
+func (settingsBar *SettingsBar) SetSettingsTexture(index int, texture graphics.Texture) {
+	graphics.DelTexture(settingsBar.SettingsTextures[index])
+	settingsBar.SettingsTextures[index] = texture
+}
+
+// SetSettingsMetadata updates metadata shown for settings at index.
+func (settingsBar *SettingsBar) SetSettingsMetadata(index int, metadata PresetMetadata) {
+	settingsBar.SettingsMetadata[index] = metadata
+}
+
+// ReorderSettings reorders displayed settings, so the settings at index i are
+// the ones which were previously at index permutation[i] (as returned by SortSettings).
+func (settingsBar *SettingsBar) ReorderSettings(permutation []int) {
+	count := len(permutation)
+	textures := make([]graphics.Texture, count)
+	colors := make([]ColorParameter, count)
+	deleteButtonColors := make([]ColorParameter, count)
+	metadata := make([]PresetMetadata, count)
+	for i, index := range permutation {
+		textures[i] = settingsBar.SettingsTextures[index]
+		colors[i] = settingsBar.SettingsColors[index]
+		deleteButtonColors[i] = settingsBar.DeleteButtonColors[index]
+		metadata[i] = settingsBar.SettingsMetadata[index]
+	}
+	settingsBar.SettingsTextures   = textures
+	settingsBar.SettingsColors     = colors
+	settingsBar.DeleteButtonColors = deleteButtonColors
+	settingsBar.SettingsMetadata   = metadata
 }
 
 // TODO: hidden refers both to hidden positionaly and UI as a whole faded out - hidden
@@ -121,11 +169,13 @@ func (settingsBar *SettingsBar) Update(dt float64, mouseX, mouseY float32, hidde
 	settingSize := mgl32.Vec2{settingSizeX, settingSizeY}
 	hiddenPortion := barPos[0] / (settingsBarWidth - settingsBarWidthHidden)
 	hiddenOffset := hiddenPortion * settingsBarWidthHidden
-	saveSize := mgl32.Vec2{settingSizeX, saveButtonHeight}
+	saveSize := mgl32.Vec2{settingSizeX - sortButtonWidth - settingPadding, saveButtonHeight}
 	savePos := mgl32.Vec2{
 		settingPadding + barPos[0] + hiddenOffset,
 		settingPadding + float32(settingsBar.ContentY.Val),
 	}
+	sortSize := mgl32.Vec2{sortButtonWidth, saveButtonHeight}
+	sortPos := mgl32.Vec2{savePos[0] + saveSize[0] + settingPadding, savePos[1]}
 	mousePos := mgl32.Vec2{mouseX, mouseY}
 	settingsCount := len(settingsBar.SettingsColors)
 
@@ -183,6 +233,23 @@ func (settingsBar *SettingsBar) Update(dt float64, mouseX, mouseY float32, hidde
 		DrawUIText("SAVE", &settingsBar.font, saveTextPos, saveTextColor, saveTextOrigin, layerFG)
 	}
 
+	// Input updates and drawing for sort button. Clicking it switches to the next sort key.
+	{
+		if isInRect(mousePos, sortPos, sortSize) {
+			settingsBar.SortButtonColor.Target = sortButtonColorHover
+			if platform.IsMouseLeftButtonPressed() {
+				settingsBar.SortKey = settingsBar.SortKey.Next()
+				returnAction = SORT
+			}
+		} else {
+			settingsBar.SortButtonColor.Target = sortButtonColor
+		}
+		DrawUIRect(sortPos, sortSize, settingsBar.SortButtonColor.Val, layerBG)
+
+		sortTextPos := mgl32.Vec2{sortPos[0] + sortSize[0]*0.5, sortPos[1] + sortSize[1]*0.5}
+		DrawUIText("BY "+settingsBar.SortKey.String(), &settingsBar.font, sortTextPos, saveTextColor, mgl32.Vec2{0.5, 0.5}, layerFG)
+	}
+
 	// Input updates and drawing for saved settings.
 	{
 		for i := 0; i < settingsCount; i++ {
@@ -201,6 +268,7 @@ func (settingsBar *SettingsBar) Update(dt float64, mouseX, mouseY float32, hidde
 	
 			if isInRect(mousePos, settingsPos, settingSize) {
 				settingsBar.SettingsColors[i].Target = settingsColorHover
+				settingsBar.drawMetadata(settingsBar.SettingsMetadata[i], settingsPos, mgl32.Vec2{settingSize[0] - deleteButtonSize[0], settingSize[1]})
 				
 				if isInRect(mousePos, deleteButtonPos, deleteButtonSize) {
 					settingsBar.DeleteButtonColors[i].Target = settingsDeleteButtonColorHover
@@ -241,10 +309,50 @@ func (settingsBar *SettingsBar) Update(dt float64, mouseX, mouseY float32, hidde
 	settingsBar.ContentY.Update(dt, 10.0)
 	settingsBar.Color.Update(dt, 4.0)
 	settingsBar.SaveButtonColor.Update(dt, 4.0)
+	settingsBar.SortButtonColor.Update(dt, 4.0)
 
 	return returnAction, returnIndex
 }
 
+// drawMetadata draws preset's name, rating, tags and timestamps over the bottom part of its thumbnail.
+func (settingsBar *SettingsBar) drawMetadata(metadata PresetMetadata, pos, size mgl32.Vec2) {
+	name := metadata.Name
+	if name == "" {
+		name = "untitled"
+	}
+	lines := []string{name + "  " + strings.Repeat("*", metadata.Rating)}
+	if len(metadata.Tags) > 0 {
+		lines = append(lines, FormatTags(metadata.Tags))
+	}
+	if !metadata.Modified.IsZero() {
+		lines = append(lines, "modified "+metadata.Modified.Format(metadataDateFormat))
+	}
+	if !metadata.Created.IsZero() {
+		lines = append(lines, "created "+metadata.Created.Format(metadataDateFormat))
+	}
+
+	rowHeight := float32(settingsBar.font.RowHeight)
+	bgSize := mgl32.Vec2{size[0], rowHeight*float32(len(lines)) + settingPadding}
+	bgPos := mgl32.Vec2{pos[0], pos[1] + size[1] - bgSize[1]}
+	DrawUIRect(bgPos, bgSize, metadataBgColor, layerFG)
+
+	textWidth := float64(size[0] - settingPadding*2)
+	for i, line := range lines {
+		// Cut off text which doesn't fit into the thumbnail, without splitting multi-byte characters.
+		line = truncateRunes(line, settingsBar.font.GetStringFit(line, textWidth))
+		textPos := mgl32.Vec2{bgPos[0] + settingPadding, bgPos[1] + settingPadding*0.5 + rowHeight*float32(i)}
+		DrawUIText(line, &settingsBar.font, textPos, metadataTextColor, mgl32.Vec2{0, 0}, layerFG)
+	}
+}
+
+// truncateRunes returns at most length bytes of text, cut at the start of a rune.
+func truncateRunes(text string, length int) string {
+	for length > 0 && length < len(text) && !utf8.RuneStart(text[length]) {
+		length--
+	}
+	return text[:length]
+}
+
 func isInRect(position mgl32.Vec2, rectPosition mgl32.Vec2, rectSize mgl32.Vec2) bool {
 	if position[0] >= rectPosition[0] && position[0] <= rectPosition[0]+rectSize[0] &&
 		position[1] >= rectPosition[1] && position[1] <= rectPosition[1]+rectSize[1] {
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preset bundle is a zip archive with ".iris" extension, containing manifest,
+// serialized AppSettings (including preset's metadata) and PNG thumbnail.
+const BundleExtension = ".iris"
+const EXPORTS_DIR = "exports"
+
+const bundleFormat = "iris-preset"
+const bundleVersion = 1
+const bundleManifestName = "manifest.json"
+const bundleSettingsName = "settings.json"
+const bundleThumbnailName = "thumbnail.png"
+
+// Limits protecting import from unreasonably large (or malicious) bundles.
+const maxBundleEntrySize = 16 << 20
+const maxThumbnailSize = 4096
+
+// Thumbnails are downscaled from scene buffer by this factor.
+const thumbnailDownscale = 3
+
+type bundleManifest struct {
+	Format  string
+	Version int
+}
+
+// ExportSettingsBundle writes settings and their thumbnail into a bundle in EXPORTS_DIR.
+// File is named after the preset. It returns path of the written bundle.
+func ExportSettingsBundle(settings AppSettings, thumbnail image.Image) (string, error) {
+	manifest, err := json.Marshal(bundleManifest{bundleFormat, bundleVersion})
+	if err != nil {
+		return "", err
+	}
+	serializedSettings, err := encodeSettings(settings)
+	if err != nil {
+		return "", err
+	}
+	var thumbnailBuffer bytes.Buffer
+	err = png.Encode(&thumbnailBuffer, thumbnail)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{bundleManifestName, manifest},
+		{bundleSettingsName, serializedSettings},
+		{bundleThumbnailName, thumbnailBuffer.Bytes()},
+	}
+	for _, entry := range entries {
+		fileWriter, err := writer.Create(entry.name)
+		if err != nil {
+			return "", err
+		}
+		_, err = fileWriter.Write(entry.data)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	os.MkdirAll(EXPORTS_DIR, 0700)
+	path := getUniquePath(EXPORTS_DIR, getBundleFileName(settings.Metadata.Name), BundleExtension)
+	err = writeFileAtomic(path, buffer.Bytes(), 0644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ImportSettingsBundle validates bundle at path and adds its settings as a new preset.
+// It returns the number of saved presets and the bundle's thumbnail.
+func ImportSettingsBundle(path string) (int, *image.NRGBA, error) {
+	settings, thumbnail, err := readSettingsBundle(path)
+	if err != nil {
+		return len(settingsList), nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+
+	// Imported preset keeps its metadata, only the modification time is updated.
+	if settings.Metadata.Created.IsZero() {
+		settings.Metadata.Created = time.Now()
+	}
+	settings.Metadata.Modified = time.Now()
+	id, err := settingsStore.Save(settings)
+	if err != nil {
+		return len(settingsList), nil, err
+	}
+	settings.id = id
+	settingsList = append(settingsList, settings)
+	return len(settingsList), thumbnail, nil
+}
+
+func readSettingsBundle(path string) (AppSettings, *image.NRGBA, error) {
+	settings := copySettings(&defaultSettings)
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return settings, nil, err
+	}
+	defer reader.Close()
+
+	entries := make(map[string][]byte)
+	for _, file := range reader.File {
+		if file.UncompressedSize64 > maxBundleEntrySize {
+			return settings, nil, fmt.Errorf("%s is too large", file.Name)
+		}
+		fileReader, err := file.Open()
+		if err != nil {
+			return settings, nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(fileReader, maxBundleEntrySize))
+		fileReader.Close()
+		if err != nil {
+			return settings, nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		entries[file.Name] = data
+	}
+
+	// Check manifest first, so we don't try to interpret some other zip file.
+	manifestData, ok := entries[bundleManifestName]
+	if !ok {
+		return settings, nil, errors.New("not a preset bundle, manifest is missing")
+	}
+	var manifest bundleManifest
+	err = json.Unmarshal(manifestData, &manifest)
+	if err != nil {
+		return settings, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Format != bundleFormat {
+		return settings, nil, fmt.Errorf("unknown bundle format %q", manifest.Format)
+	}
+	if manifest.Version > bundleVersion {
+		return settings, nil, fmt.Errorf("bundle version %d is newer than supported version %d", manifest.Version, bundleVersion)
+	}
+
+	settingsData, ok := entries[bundleSettingsName]
+	if !ok {
+		return settings, nil, errors.New("settings are missing")
+	}
+	settings, _, err = decodeSettings(settingsData)
+	if err != nil {
+		return settings, nil, fmt.Errorf("invalid settings: %v", err)
+	}
+
+	thumbnailData, ok := entries[bundleThumbnailName]
+	if !ok {
+		return settings, nil, errors.New("thumbnail is missing")
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(thumbnailData))
+	if err != nil {
+		return settings, nil, fmt.Errorf("invalid thumbnail: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxThumbnailSize || config.Height > maxThumbnailSize {
+		return settings, nil, fmt.Errorf("invalid thumbnail size %dx%d", config.Width, config.Height)
+	}
+	thumbnailImage, err := png.Decode(bytes.NewReader(thumbnailData))
+	if err != nil {
+		return settings, nil, fmt.Errorf("invalid thumbnail: %v", err)
+	}
+	return settings, toNRGBA(thumbnailImage), nil
+}
+
+// GetThumbnailImage converts scene buffer pixels (RGBA, rows top to bottom) into a downscaled thumbnail.
+func GetThumbnailImage(pixels []byte, width, height int) *image.NRGBA {
+	thumbnailWidth, thumbnailHeight := width/thumbnailDownscale, height/thumbnailDownscale
+	thumbnail := image.NewNRGBA(image.Rect(0, 0, thumbnailWidth, thumbnailHeight))
+	for y := 0; y < thumbnailHeight; y++ {
+		for x := 0; x < thumbnailWidth; x++ {
+			// Average block of source pixels.
+			var sum [4]int
+			for by := 0; by < thumbnailDownscale; by++ {
+				for bx := 0; bx < thumbnailDownscale; bx++ {
+					offset := ((y*thumbnailDownscale+by)*width + x*thumbnailDownscale + bx) * 4
+					for c := 0; c < 4; c++ {
+						sum[c] += int(pixels[offset+c])
+					}
+				}
+			}
+			offset := thumbnail.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				thumbnail.Pix[offset+c] = uint8(sum[c] / (thumbnailDownscale * thumbnailDownscale))
+			}
+		}
+	}
+	return thumbnail
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			nrgba.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return nrgba
+}
+
+// getBundleFileName turns preset name into a safe file name.
+func getBundleFileName(name string) string {
+	fileName := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		} else if r == ' ' {
+			return '_'
+		}
+		return -1
+	}, name)
+	if fileName == "" {
+		fileName = "preset"
+	}
+	return fileName
+}
+
+// getUniquePath returns path in dir for file with name and extension which doesn't exist yet.
+func getUniquePath(dir, name, extension string) string {
+	path := filepath.Join(dir, name+extension)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, name+"_"+strconv.Itoa(i)+extension)
+	}
+}
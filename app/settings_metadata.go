@@ -0,0 +1,124 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// PresetMetadata describes saved preset. It doesn't affect how the preset looks.
+type PresetMetadata struct {
+	Name     string
+	Tags     []string
+	Created  time.Time
+	Modified time.Time
+	Rating   int
+}
+
+// Maximum preset rating, in stars.
+const MaxRating = 5
+
+// SettingsSortKey specifies how are presets ordered.
+type SettingsSortKey int
+const (
+	SortBySaveOrder SettingsSortKey = iota
+	SortByName
+	SortByTags
+	SortByCreated
+	SortByModified
+	SortByRating
+	sortKeyCount
+)
+
+var sortKeyNames = [...]string{"SAVED", "NAME", "TAGS", "CREATED", "MODIFIED", "RATING"}
+
+func (key SettingsSortKey) String() string {
+	return sortKeyNames[key]
+}
+
+// Next returns the following sort key, wrapping around after the last one.
+func (key SettingsSortKey) Next() SettingsSortKey {
+	return (key + 1) % sortKeyCount
+}
+
+// SortSettings reorders presets by key and returns the permutation applied - preset at
+// index i after sorting was at index permutation[i] before. Settings bar shows the last
+// preset on top, so presets are sorted in such way that the "first" one ends up last
+// (e.g. highest rating, newest, alphabetically first name).
+func SortSettings(key SettingsSortKey) []int {
+	permutation := make([]int, len(settingsList))
+	for i := range permutation {
+		permutation[i] = i
+	}
+
+	// less reports whether preset a should be shown below preset b.
+	less := func(a, b *AppSettings) bool {
+		switch key {
+		case SortByName:
+			return strings.ToLower(a.Metadata.Name) > strings.ToLower(b.Metadata.Name)
+		case SortByTags:
+			return strings.ToLower(FormatTags(a.Metadata.Tags)) > strings.ToLower(FormatTags(b.Metadata.Tags))
+		case SortByCreated:
+			return a.Metadata.Created.Before(b.Metadata.Created)
+		case SortByModified:
+			return a.Metadata.Modified.Before(b.Metadata.Modified)
+		case SortByRating:
+			return a.Metadata.Rating < b.Metadata.Rating
+		}
+		return false
+	}
+	sort.SliceStable(permutation, func(i, j int) bool {
+		a, b := &settingsList[permutation[i]], &settingsList[permutation[j]]
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+		// Presets which are equal are kept in the order in which they were saved.
+		return a.id < b.id
+	})
+
+	sortedList := make([]AppSettings, len(settingsList))
+	for i, index := range permutation {
+		sortedList[i] = settingsList[index]
+	}
+	settingsList = sortedList
+	return permutation
+}
+
+// GetSettingsMetadata returns metadata of preset at index.
+func GetSettingsMetadata(index int) PresetMetadata {
+	settings := copySettings(&settingsList[index])
+	return settings.Metadata
+}
+
+// UpdateSettingsMetadata changes metadata of preset at index and saves it into store.
+func UpdateSettingsMetadata(index int, metadata PresetMetadata) error {
+	settings := copySettings(&settingsList[index])
+	settings.id = settingsList[index].id
+	settings.Metadata = metadata
+	settings.Metadata.Modified = time.Now()
+	err := settingsStore.Update(settings.id, settings)
+	if err != nil {
+		return err
+	}
+	settingsList[index] = settings
+	return nil
+}
+
+// ParseTags splits comma-separated list of tags.
+func ParseTags(text string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(text, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// FormatTags joins tags into comma-separated list.
+func FormatTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
@@ -0,0 +1,250 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// currentSettingsVersion is the schema version stamped into every saved settings file.
+// Whenever the saved shape of AppSettings changes, bump this number and append
+// a migration to settingsMigrations.
+const currentSettingsVersion = 14
+
+// settingsMigration upgrades serialized settings by a single version. Settings are
+// handled as generic JSON objects, so migrations don't depend on the current Go structs.
+type settingsMigration func(settings map[string]interface{})
+
+// settingsMigrations[i] upgrades settings from version i to version i + 1.
+var settingsMigrations = []settingsMigration{
+	migrateSettingsV0,
+	migrateSettingsV1,
+	migrateSettingsV2,
+	migrateSettingsV3,
+	migrateSettingsV4,
+	migrateSettingsV5,
+	migrateSettingsV6,
+	migrateSettingsV7,
+	migrateSettingsV8,
+	migrateSettingsV9,
+	migrateSettingsV10,
+	migrateSettingsV11,
+	migrateSettingsV12,
+	migrateSettingsV13,
+}
+
+// migrateSettingsV0 upgrades settings saved before versioning was introduced. They have cells, rendering
+// and camera settings at the top level and were read on top of the defaults of that time, so fields
+// missing from them get those defaults. Defaults are written out, so they don't change with current ones.
+func migrateSettingsV0(settings map[string]interface{}) {
+	cells := getJSONObject(settings, "Cells")
+	setJSONDefaults(cells, map[string]interface{}{
+		"PolarStd": 0.02, "PolarMean": math.Pi / 2.0,
+		"RadiusMin": 3.0, "RadiusMax": 15.0,
+		"HeightRatio": 1.0,
+		"Count": 5000,
+		"Colors": []interface{}{
+			[]interface{}{24 / 255.0, 193 / 255.0, 236 / 255.0, 1.0},
+			[]interface{}{0 / 255.0, 185 / 255.0, 121 / 255.0, 1.0},
+			[]interface{}{236 / 255.0, 24 / 255.0, 97 / 255.0, 1.0},
+			[]interface{}{33 / 255.0, 73 / 255.0, 83 / 255.0, 1.0},
+			[]interface{}{194 / 255.0, 55 / 255.0, 48 / 255.0, 1.0},
+		},
+	})
+	setJSONDefaults(getJSONObject(settings, "Rendering"), map[string]interface{}{
+		"DirectLight": 0.5, "AmbientLight": 0.75,
+		"Roughness": 1.0, "Reflectivity": 0.05,
+		"SSAORadius": 0.5, "SSAORange": 3.0, "SSAOBoundary": 1.0,
+		"MinWhite": 8.0,
+	})
+	setJSONDefaults(getJSONObject(settings, "Camera"), map[string]interface{}{
+		"Radius": 100.0, "Azimuth": 0.0, "Polar": 0.0, "Height": 0.0,
+	})
+}
+
+// migrateSettingsV1 adds preset metadata. Name, tags and rating are left empty,
+// stores fill in missing timestamps when they know them.
+func migrateSettingsV1(settings map[string]interface{}) {
+	getJSONObject(settings, "Metadata")
+}
+
+// migrateSettingsV2 adds seed of cells. Layouts of older presets were never saved,
+// so they all get the seed cells were generated with back then.
+func migrateSettingsV2(settings map[string]interface{}) {
+	setJSONDefault(getJSONObject(settings, "Cells"), "Seed", 1)
+}
+
+// migrateSettingsV3 adds distribution of cells. Older presets used the one which is now the default.
+func migrateSettingsV3(settings map[string]interface{}) {
+	distribution := getJSONObject(getJSONObject(settings, "Cells"), "Distribution")
+	setJSONDefault(distribution, "Name", "iris")
+	getJSONObject(distribution, "Parameters")
+}
+
+// migrateSettingsV4 turns cell settings into the first (and only) layer. Material used to be
+// shared by the whole scene, now every layer has its own.
+func migrateSettingsV4(settings map[string]interface{}) {
+	layer := getJSONObject(settings, "Cells")
+	rendering := getJSONObject(settings, "Rendering")
+	material := getJSONObject(layer, "Material")
+	for _, key := range []string{"Roughness", "Reflectivity"} {
+		if value, ok := rendering[key]; ok {
+			material[key] = value
+			delete(rendering, key)
+		}
+	}
+	settings["Layers"] = []interface{}{layer}
+	delete(settings, "Cells")
+}
+
+// migrateSettingsV5 adds dimensions of cells to every layer. They used to be fixed,
+// older presets get the ones which were used back then.
+func migrateSettingsV5(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefaults(getJSONObject(layer, "Scale"), map[string]interface{}{
+			"Distribution": "squared",
+			"WidthMin": 0.11, "WidthMax": 2.75,
+			"DepthMin": 0.16, "DepthMax": 4.0,
+			"Uniform": false,
+		})
+	})
+}
+
+// migrateSettingsV6 adds shape of cells to every layer. Cells of older presets were always boxes
+// with sharp edges.
+func migrateSettingsV6(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefaults(getJSONObject(layer, "Shape"), map[string]interface{}{"Name": "box", "Bevel": 0.0})
+	})
+}
+
+// migrateSettingsV7 adds mesh file of cells to every layer. Older presets don't use any.
+func migrateSettingsV7(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefault(getJSONObject(layer, "Shape"), "Mesh", "")
+	})
+}
+
+// migrateSettingsV8 adds point-cloud file of cells to every layer. Older presets don't use any.
+func migrateSettingsV8(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefaults(getJSONObject(layer, "Points"), map[string]interface{}{"File": "", "Layer": 0})
+	})
+}
+
+// migrateSettingsV9 adds overlap settings to every layer. Older presets let cells overlap.
+func migrateSettingsV9(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefaults(getJSONObject(layer, "Overlap"), map[string]interface{}{"Avoid": false, "MinGap": 0.0})
+	})
+}
+
+// migrateSettingsV10 adds overrides of cells to every layer. Older presets don't have any.
+func migrateSettingsV10(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefault(layer, "Overrides", []interface{}{})
+	})
+}
+
+// migrateSettingsV11 adds symmetry settings to every layer. Older presets have no symmetry.
+func migrateSettingsV11(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		setJSONDefaults(getJSONObject(layer, "Symmetry"), map[string]interface{}{
+			"Fold": 1, "MirrorX": false, "MirrorY": false, "MirrorZ": false,
+		})
+	})
+}
+
+// migrateSettingsV12 adds coloring settings to every layer. Older presets color cells randomly.
+func migrateSettingsV12(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		coloring := getJSONObject(layer, "Coloring")
+		setJSONDefault(coloring, "Name", "random")
+		getJSONObject(coloring, "Parameters")
+	})
+}
+
+// migrateSettingsV13 adds animation settings to every layer. Cells of older presets don't move.
+func migrateSettingsV13(settings map[string]interface{}) {
+	forEachJSONLayer(settings, func(layer map[string]interface{}) {
+		animation := getJSONObject(layer, "Animation")
+		for _, name := range []string{"Orbit", "Breathe", "Wave", "Spin"} {
+			setJSONDefaults(getJSONObject(animation, name), map[string]interface{}{"Speed": 0.0, "Amplitude": 0.0})
+		}
+	})
+}
+
+// migrateSettings upgrades serialized settings to currentSettingsVersion.
+// It returns upgraded data and whether any migration was applied.
+func migrateSettings(data []byte) ([]byte, bool, error) {
+	// Numbers are kept as they are, so large integers (e.g. seeds) don't lose precision.
+	var settings map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&settings)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Files without version field are considered to be version 0.
+	version := 0
+	if versionValue, ok := settings["Version"].(json.Number); ok {
+		versionNumber, err := versionValue.Int64()
+		if err != nil || versionNumber < 0 {
+			return nil, false, fmt.Errorf("invalid settings version %s", versionValue)
+		}
+		version = int(versionNumber)
+	}
+	if version > currentSettingsVersion {
+		return nil, false, fmt.Errorf("settings version %d is newer than supported version %d", version, currentSettingsVersion)
+	}
+	if version == currentSettingsVersion {
+		return data, false, nil
+	}
+
+	for ; version < currentSettingsVersion; version++ {
+		settingsMigrations[version](settings)
+	}
+	settings["Version"] = currentSettingsVersion
+
+	data, err = json.Marshal(settings)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// getJSONObject returns JSON object stored under key, creating it if it's missing.
+func getJSONObject(parent map[string]interface{}, key string) map[string]interface{} {
+	object, ok := parent[key].(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+		parent[key] = object
+	}
+	return object
+}
+
+// setJSONDefault stores value under key of object, unless the key is already there.
+func setJSONDefault(object map[string]interface{}, key string, value interface{}) {
+	if _, ok := object[key]; !ok {
+		object[key] = value
+	}
+}
+
+// setJSONDefaults stores all the values under their keys of object, unless the keys are already there.
+func setJSONDefaults(object map[string]interface{}, values map[string]interface{}) {
+	for key, value := range values {
+		setJSONDefault(object, key, value)
+	}
+}
+
+// forEachJSONLayer calls visit with every layer of settings which is a JSON object.
+func forEachJSONLayer(settings map[string]interface{}, visit func(layer map[string]interface{})) {
+	layers, _ := settings["Layers"].([]interface{})
+	for _, layer := range layers {
+		if layer, ok := layer.(map[string]interface{}); ok {
+			visit(layer)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Fixtures in testdata/settings have the shape settings had at every historical version,
+// vN.json is version N. Every version has values of the fields it introduced changed
+// from defaults, so the test can tell they survive later migrations.
+var settingsMigrationFixtures = []func(t *testing.T, settings *AppSettings){
+	// Version 0 is the original format, values missing from it get defaults of that time.
+	func(t *testing.T, settings *AppSettings) {
+		layer := &settings.Layers[0]
+		expectValue(t, "PolarStd", layer.PolarStd, 0.1)
+		expectValue(t, "RadiusMax", layer.RadiusMax, 20.0)
+		expectValue(t, "Count", layer.Count, 1234)
+		expectValue(t, "HeightRatio", layer.HeightRatio, 1.0)
+		expectValue(t, "Colors", len(layer.Colors), 5)
+		expectValue(t, "Color", layer.Colors[0], mgl32.Vec4{24 / 255.0, 193 / 255.0, 236 / 255.0, 1.0})
+		expectValue(t, "Roughness", layer.Material.Roughness, 0.3)
+		expectValue(t, "DirectLight", settings.Rendering.DirectLight, 0.6)
+		expectValue(t, "MinWhite", settings.Rendering.MinWhite, 8.0)
+		expectValue(t, "Camera", settings.Camera, CameraSettings{100.0, 0.0, 0.0, 0.0})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		layer := &settings.Layers[0]
+		expectValue(t, "HeightRatio", layer.HeightRatio, 2.0)
+		expectValue(t, "Colors", len(layer.Colors), 3)
+		expectValue(t, "MinWhite", settings.Rendering.MinWhite, 6.0)
+		expectValue(t, "Camera", settings.Camera, CameraSettings{80.0, 0.5, 0.1, 1.0})
+		expectValue(t, "Name", settings.Metadata.Name, "")
+		expectValue(t, "Seed", layer.Seed, int64(1))
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Name", settings.Metadata.Name, "Fixture")
+		expectValue(t, "Tags", fmt.Sprint(settings.Metadata.Tags), "[old test]")
+		expectValue(t, "Rating", settings.Metadata.Rating, 4)
+		expectValue(t, "Created", settings.Metadata.Created.Format("2006-01-02"), "2026-01-02")
+		expectValue(t, "Seed", settings.Layers[0].Seed, int64(1))
+		expectValue(t, "Distribution", settings.Layers[0].Distribution.Name, "iris")
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Seed", settings.Layers[0].Seed, int64(42))
+		expectValue(t, "Distribution", settings.Layers[0].Distribution.Name, "iris")
+		expectValue(t, "Layers", len(settings.Layers), 1)
+	},
+	func(t *testing.T, settings *AppSettings) {
+		layer := &settings.Layers[0]
+		expectValue(t, "Layers", len(settings.Layers), 1)
+		expectValue(t, "Distribution", layer.Distribution.Name, "torus")
+		expectValue(t, "Thickness", layer.Distribution.Parameters["Thickness"], 0.25)
+		expectValue(t, "Material", layer.Material, MaterialSettings{0.3, 0.2})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Layers", len(settings.Layers), 2)
+		expectValue(t, "Count", settings.Layers[1].Count, 10)
+		expectValue(t, "Seed", settings.Layers[1].Seed, int64(7))
+		for _, layer := range settings.Layers {
+			expectValue(t, "Scale", layer.Scale, ScaleSettings{"squared", 0.11, 2.75, 0.16, 4.0, false})
+		}
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Scale", settings.Layers[0].Scale, ScaleSettings{"linear", 0.5, 1.5, 0.2, 3.0, true})
+		expectValue(t, "Shape", settings.Layers[0].Shape, ShapeSettings{"box", 0.0, ""})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Shape", settings.Layers[0].Shape, ShapeSettings{"box", 0.1, ""})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Shape", settings.Layers[0].Shape, ShapeSettings{"box", 0.1, "meshes/cell.obj"})
+		expectValue(t, "Points", settings.Layers[0].Points, PointsSettings{})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Points", settings.Layers[0].Points, PointsSettings{"points/cells.csv", 2})
+		expectValue(t, "Overlap", settings.Layers[0].Overlap, OverlapSettings{})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Overlap", settings.Layers[0].Overlap, OverlapSettings{true, 0.2})
+		expectValue(t, "Overrides", len(settings.Layers[0].Overrides), 0)
+	},
+	func(t *testing.T, settings *AppSettings) {
+		layer := &settings.Layers[0]
+		expectValue(t, "Overrides", len(layer.Overrides), 1)
+		expectValue(t, "Override", layer.Overrides[0], CellOverride{3, 42, true, mgl32.Vec4{1, 1, 1, 1}, 2.0, false})
+		expectValue(t, "Symmetry", layer.Symmetry, SymmetrySettings{Fold: 1})
+	},
+	func(t *testing.T, settings *AppSettings) {
+		expectValue(t, "Symmetry", settings.Layers[0].Symmetry, SymmetrySettings{3, true, false, false})
+		expectValue(t, "Coloring", settings.Layers[0].Coloring.Name, "random")
+	},
+	func(t *testing.T, settings *AppSettings) {
+		layer := &settings.Layers[0]
+		expectValue(t, "Coloring", layer.Coloring.Name, "noise")
+		expectValue(t, "Frequency", layer.Coloring.Parameters["Frequency"], 0.5)
+		expectValue(t, "Animation", layer.Animation, AnimationSettings{})
+	},
+}
+
+func expectValue(t *testing.T, name string, value, expected interface{}) {
+	t.Helper()
+	if fmt.Sprint(value) != fmt.Sprint(expected) {
+		t.Errorf("%s is %v, expected %v", name, value, expected)
+	}
+}
+
+func TestSettingsMigrationFixtures(t *testing.T) {
+	if len(settingsMigrationFixtures) != currentSettingsVersion {
+		t.Fatalf("there are %d fixtures for %d versions", len(settingsMigrationFixtures), currentSettingsVersion)
+	}
+	for version, check := range settingsMigrationFixtures {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "settings", fmt.Sprintf("v%d.json", version)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings, migrated, err := decodeSettings(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !migrated {
+				t.Error("settings weren't migrated")
+			}
+			// Animation introduced by the latest version keeps its defaults.
+			layer := &settings.Layers[0]
+			expectValue(t, "Animation", IsAnimated(&layer.Animation), false)
+			check(t, &settings)
+
+			// Migrated settings are saved with the current version and load without migration.
+			data, err = encodeSettings(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, migrated, err = decodeSettings(data); err != nil || migrated {
+				t.Errorf("migrated settings were migrated again (error %v)", err)
+			}
+		})
+	}
+}
+
+func TestSettingsMigrationKeepsLargeSeeds(t *testing.T) {
+	data := []byte(`{"Version": 3, "Cells": {"Seed": 9007199254740993}}`)
+	settings, _, err := decodeSettings(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, "Seed", settings.Layers[0].Seed, int64(9007199254740993))
+}
+
+func TestSettingsMigrationRejectsInvalidVersions(t *testing.T) {
+	for _, version := range []string{"-1", "1.5", fmt.Sprint(currentSettingsVersion + 1), fmt.Sprint(math.MaxInt64)} {
+		data := []byte(`{"Version": ` + version + `, "Layers": []}`)
+		if _, _, err := migrateSettings(data); err == nil {
+			t.Errorf("version %s was accepted", version)
+		}
+	}
+}
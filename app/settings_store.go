@@ -0,0 +1,281 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SettingsStore is a collection of saved presets. Presets are identified by ids
+// assigned by the store when they're saved.
+type SettingsStore interface {
+	// List returns ids of all stored presets, in order in which they were saved.
+	List() ([]int, error)
+	// Get returns preset with specified id.
+	Get(id int) (AppSettings, error)
+	// Save stores settings as a new preset and returns its id.
+	Save(settings AppSettings) (int, error)
+	// Update replaces existing preset with specified id.
+	Update(id int, settings AppSettings) error
+	// Delete removes preset with specified id.
+	Delete(id int) error
+	// Watch returns channel signalling that stored presets might have changed,
+	// including changes made by other processes, and function which stops watching.
+	Watch() (<-chan struct{}, func())
+}
+
+// settingsQuarantiner is implemented by stores which can put aside presets that cannot be loaded.
+type settingsQuarantiner interface {
+	Quarantine(id int)
+}
+
+// How often are stores backed by files checked for changes.
+const settingsWatchInterval = time.Second
+
+const corruptDirName = "corrupt"
+const lockFileName = ".lock"
+const presetFilePrefix = "settings_"
+const nextIDFileName = ".next_id"
+
+// DirectorySettingsStore stores each preset as a separate file in a directory.
+// Access to the directory is guarded by an advisory lock, so multiple running
+// instances can share it.
+type DirectorySettingsStore struct {
+	dir string
+}
+
+// GetDirectorySettingsStore returns store of presets in dir. The directory is created if necessary.
+func GetDirectorySettingsStore(dir string) *DirectorySettingsStore {
+	os.MkdirAll(dir, 0700)
+	return &DirectorySettingsStore{dir}
+}
+
+func (store *DirectorySettingsStore) getPath(id int) string {
+	return filepath.Join(store.dir, presetFilePrefix+strconv.Itoa(id))
+}
+
+func (store *DirectorySettingsStore) lock() (*fileLock, error) {
+	return lockFile(filepath.Join(store.dir, lockFileName))
+}
+
+// List returns ids of all presets in the directory.
+func (store *DirectorySettingsStore) List() ([]int, error) {
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for _, file := range files {
+		id, ok := getSaveNum(file.Name())
+		if file.IsDir() || !ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// Get loads preset from its file. Preset which had to be migrated is rewritten.
+// Presets saved without creation time get file's modification time instead.
+// Getting preset which doesn't exist fails with error wrapping os.ErrNotExist.
+func (store *DirectorySettingsStore) Get(id int) (AppSettings, error) {
+	path := store.getPath(id)
+	if _, err := os.Stat(path); err != nil {
+		return copySettings(&defaultSettings), fmt.Errorf("preset %d: %w", id, err)
+	}
+	settings, err := loadSingleSettings(path)
+	if err != nil {
+		return settings, err
+	}
+	if settings.Metadata.Created.IsZero() {
+		if info, err := os.Stat(path); err == nil {
+			settings.Metadata.Created = info.ModTime()
+			settings.Metadata.Modified = info.ModTime()
+		}
+	}
+	return settings, nil
+}
+
+// Save writes settings into a new file.
+func (store *DirectorySettingsStore) Save(settings AppSettings) (int, error) {
+	// Directory is locked while choosing the preset number, so no other instance can take it.
+	lock, err := store.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	id, err := store.takeNextID()
+	if err != nil {
+		return 0, err
+	}
+	err = saveSingleSettings(store.getPath(id), settings)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// takeNextID returns id for a new preset and stores the following one. Ids are taken from a counter
+// kept in the directory, so ids of deleted presets are never reused, other instances could still refer
+// to them. Directories without the counter continue after the last preset. Directory must be locked.
+func (store *DirectorySettingsStore) takeNextID() (int, error) {
+	// The directory is scanned every time, since other instances could have saved presets as well.
+	ids, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	path := filepath.Join(store.dir, nextIDFileName)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if nextID, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && nextID > id {
+			id = nextID
+		}
+	}
+	err = writeFileAtomic(path, []byte(strconv.Itoa(id + 1)), 0644)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Update rewrites preset's file.
+func (store *DirectorySettingsStore) Update(id int, settings AppSettings) error {
+	lock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := store.getPath(id)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return saveSingleSettings(path, settings)
+}
+
+// Delete removes preset's file. Preset which doesn't exist is considered deleted.
+func (store *DirectorySettingsStore) Delete(id int) error {
+	lock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	err = os.Remove(store.getPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Quarantine moves preset's file into "corrupt" subdirectory, so it's not loaded again, but it's not lost either.
+func (store *DirectorySettingsStore) Quarantine(id int) {
+	lock, err := store.lock()
+	if err != nil {
+		log.Printf("settings: couldn't lock %s: %v", store.dir, err)
+		return
+	}
+	defer lock.Unlock()
+
+	quarantineFile(store.getPath(id), filepath.Join(store.dir, corruptDirName))
+}
+
+// Watch polls the directory and signals when preset files are added, removed or rewritten.
+func (store *DirectorySettingsStore) Watch() (<-chan struct{}, func()) {
+	return watchPolling(store.getState)
+}
+
+// getState returns names, modification times and sizes of all preset files.
+func (store *DirectorySettingsStore) getState() string {
+	files, _ := ioutil.ReadDir(store.dir)
+	states := make([]string, 0, len(files))
+	for _, file := range files {
+		if _, ok := getSaveNum(file.Name()); ok {
+			states = append(states, file.Name() + "/" + file.ModTime().String() + "/" + strconv.FormatInt(file.Size(), 10))
+		}
+	}
+	return strings.Join(states, ",")
+}
+
+// getSaveNum returns number N from preset file name in form of "settings_N".
+// Other files (lock, temporary files etc.) are not presets.
+func getSaveNum(fileName string) (int, bool) {
+	if !strings.HasPrefix(fileName, presetFilePrefix) {
+		return 0, false
+	}
+	fileNum, err := strconv.Atoi(strings.TrimPrefix(fileName, presetFilePrefix))
+	if err != nil {
+		return 0, false
+	}
+	return fileNum, true
+}
+
+// quarantineFile moves file which couldn't be loaded to dir. The file gets time of the move
+// (and a counter, if needed) appended to its name, so it doesn't replace files moved before.
+func quarantineFile(path, dir string) {
+	os.MkdirAll(dir, 0700)
+	quarantinePath := getQuarantinePath(path, dir, time.Now())
+	err := os.Rename(path, quarantinePath)
+	if err != nil {
+		log.Printf("settings: couldn't move %s to %s: %v", path, quarantinePath, err)
+		return
+	}
+	log.Printf("settings: moved %s to %s", path, quarantinePath)
+}
+
+// getQuarantinePath returns path in dir, not used by any file yet, for file at path quarantined at time.
+func getQuarantinePath(path, dir string, time time.Time) string {
+	name := filepath.Base(path) + "." + time.Format("20060102-150405")
+	quarantinePath := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(quarantinePath); err != nil {
+			return quarantinePath
+		}
+		quarantinePath = filepath.Join(dir, name + "." + strconv.Itoa(i))
+	}
+}
+
+// watchPolling periodically calls getState and signals on returned channel whenever the state changes.
+// Polling goes on until the returned stop function is called.
+func watchPolling(getState func() string) (<-chan struct{}, func()) {
+	changes := make(chan struct{}, 1)
+	done := make(chan struct{})
+	ticker := time.NewTicker(settingsWatchInterval)
+	lastState := getState()
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			state := getState()
+			if state == lastState {
+				continue
+			}
+			lastState = state
+			// Don't block if the previous change wasn't handled yet, single signal is enough.
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	return changes, func() {
+		stopOnce.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ArchiveSettingsStore stores all presets in a single zip archive, so the whole
+// collection can be carried around as one file. Each preset is stored as an entry
+// named the same way as files in DirectorySettingsStore, counter of preset ids is stored
+// in its own entry.
+type ArchiveSettingsStore struct {
+	path string
+	// Entries read from the archive and state of the archive file they were read from,
+	// the archive is read again only after it changes.
+	mutex      sync.Mutex
+	entries    map[int][]byte
+	nextID     int
+	entryState string
+}
+
+const archiveNextIDName = "next_id"
+
+// GetArchiveSettingsStore returns store of presets in zip archive at path.
+// The archive is created on the first save.
+func GetArchiveSettingsStore(path string) *ArchiveSettingsStore {
+	return &ArchiveSettingsStore{path: path}
+}
+
+// lock guards read-modify-write of the archive against other running instances.
+func (store *ArchiveSettingsStore) lock() (*fileLock, error) {
+	return lockFile(store.path + lockFileName)
+}
+
+// getState returns modification time and size of the archive, or empty string if it doesn't exist.
+func (store *ArchiveSettingsStore) getState() string {
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return ""
+	}
+	return info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+}
+
+// readEntries returns serialized presets stored in the archive, mapped by their ids, and id of the next
+// saved preset. The archive is indexed once and read again only when it changes. Returned map is a copy,
+// so callers can modify it. Entries which cannot be read are skipped.
+func (store *ArchiveSettingsStore) readEntries() (map[int][]byte, int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	state := store.getState()
+	if store.entries == nil || state != store.entryState {
+		entries, nextID, err := readArchiveEntries(store.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		store.entries = entries
+		store.nextID = nextID
+		store.entryState = state
+	}
+	entries := make(map[int][]byte, len(store.entries))
+	for id, data := range store.entries {
+		entries[id] = data
+	}
+	return entries, store.nextID, nil
+}
+
+// readArchiveEntries reads serialized presets from zip archive at path, mapped by their ids, and id
+// of the next saved preset. Archives without the counter continue after the last preset.
+func readArchiveEntries(path string) (map[int][]byte, int, error) {
+	entries := make(map[int][]byte)
+	nextID := 1
+	reader, err := zip.OpenReader(path)
+	if os.IsNotExist(err) {
+		return entries, nextID, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		id, ok := getSaveNum(file.Name)
+		if !ok && file.Name != archiveNextIDName {
+			continue
+		}
+		data, err := readArchiveEntry(file)
+		if err != nil {
+			log.Printf("settings: skipping %s in %s: %v", file.Name, path, err)
+			continue
+		}
+		if !ok {
+			if storedID, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && storedID > nextID {
+				nextID = storedID
+			}
+			continue
+		}
+		entries[id] = data
+		if id >= nextID {
+			nextID = id + 1
+		}
+	}
+	return entries, nextID, nil
+}
+
+func readArchiveEntry(file *zip.File) ([]byte, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	return ioutil.ReadAll(fileReader)
+}
+
+// writeEntries replaces the archive with one containing specified entries and id of the next saved preset.
+func (store *ArchiveSettingsStore) writeEntries(entries map[int][]byte, nextID int) error {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	fileWriter, err := writer.Create(archiveNextIDName)
+	if err != nil {
+		return err
+	}
+	_, err = fileWriter.Write([]byte(strconv.Itoa(nextID)))
+	if err != nil {
+		return err
+	}
+	for _, id := range getSortedIDs(entries) {
+		fileWriter, err := writer.Create(presetFilePrefix + strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		_, err = fileWriter.Write(entries[id])
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, buffer.Bytes(), 0644)
+}
+
+// List returns ids of all presets in the archive.
+func (store *ArchiveSettingsStore) List() ([]int, error) {
+	entries, _, err := store.readEntries()
+	if err != nil {
+		return nil, err
+	}
+	return getSortedIDs(entries), nil
+}
+
+// Get loads preset from the archive. Migrated presets are not rewritten,
+// since that would mean rewriting the whole archive.
+func (store *ArchiveSettingsStore) Get(id int) (AppSettings, error) {
+	entries, _, err := store.readEntries()
+	if err != nil {
+		return copySettings(&defaultSettings), err
+	}
+	data, ok := entries[id]
+	if !ok {
+		return copySettings(&defaultSettings), fmt.Errorf("preset %d: %w", id, os.ErrNotExist)
+	}
+	settings, _, err := decodeSettings(data)
+	return settings, err
+}
+
+// Save adds settings to the archive as a new entry. Ids of deleted presets are never reused,
+// other instances could still refer to them.
+func (store *ArchiveSettingsStore) Save(settings AppSettings) (int, error) {
+	lock, err := store.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	entries, id, err := store.readEntries()
+	if err != nil {
+		return 0, err
+	}
+	entries[id], err = encodeSettings(settings)
+	if err != nil {
+		return 0, err
+	}
+	err = store.writeEntries(entries, id + 1)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Update replaces preset's entry in the archive.
+func (store *ArchiveSettingsStore) Update(id int, settings AppSettings) error {
+	lock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	entries, nextID, err := store.readEntries()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[id]; !ok {
+		return fmt.Errorf("preset %d: %w", id, os.ErrNotExist)
+	}
+	entries[id], err = encodeSettings(settings)
+	if err != nil {
+		return err
+	}
+	return store.writeEntries(entries, nextID)
+}
+
+// Delete removes preset's entry from the archive.
+func (store *ArchiveSettingsStore) Delete(id int) error {
+	lock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	entries, nextID, err := store.readEntries()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[id]; !ok {
+		return nil
+	}
+	delete(entries, id)
+	return store.writeEntries(entries, nextID)
+}
+
+// Watch polls the archive and signals when it's modified.
+func (store *ArchiveSettingsStore) Watch() (<-chan struct{}, func()) {
+	return watchPolling(store.getState)
+}
+
+func getSortedIDs(entries map[int][]byte) []int {
+	ids := make([]int, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// MemorySettingsStore keeps presets only in memory. It's useful when presets
+// shouldn't be persisted, e.g. in tests.
+type MemorySettingsStore struct {
+	mutex    sync.Mutex
+	presets  map[int]AppSettings
+	lastID   int
+	watchers []chan struct{}
+}
+
+// GetMemorySettingsStore returns an empty in-memory store.
+func GetMemorySettingsStore() *MemorySettingsStore {
+	return &MemorySettingsStore{presets: make(map[int]AppSettings)}
+}
+
+// List returns ids of all stored presets.
+func (store *MemorySettingsStore) List() ([]int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Ids are assigned in increasing order, so we can just walk through them.
+	ids := make([]int, 0, len(store.presets))
+	for id := 1; id <= store.lastID; id++ {
+		if _, ok := store.presets[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Get returns a copy of stored preset.
+func (store *MemorySettingsStore) Get(id int) (AppSettings, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	settings, ok := store.presets[id]
+	if !ok {
+		return copySettings(&defaultSettings), fmt.Errorf("preset %d: %w", id, os.ErrNotExist)
+	}
+	return copySettings(&settings), nil
+}
+
+// Save stores a copy of settings.
+func (store *MemorySettingsStore) Save(settings AppSettings) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.lastID++
+	store.presets[store.lastID] = copySettings(&settings)
+	store.notify()
+	return store.lastID, nil
+}
+
+// Update replaces stored preset with a copy of settings.
+func (store *MemorySettingsStore) Update(id int, settings AppSettings) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.presets[id]; !ok {
+		return fmt.Errorf("preset %d: %w", id, os.ErrNotExist)
+	}
+	store.presets[id] = copySettings(&settings)
+	store.notify()
+	return nil
+}
+
+// Delete removes preset from store.
+func (store *MemorySettingsStore) Delete(id int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.presets, id)
+	store.notify()
+	return nil
+}
+
+// Watch signals after every change of the stored presets, until stopped.
+func (store *MemorySettingsStore) Watch() (<-chan struct{}, func()) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	changes := make(chan struct{}, 1)
+	store.watchers = append(store.watchers, changes)
+	return changes, func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		for i, watcher := range store.watchers {
+			if watcher == changes {
+				store.watchers = append(store.watchers[:i], store.watchers[i + 1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (store *MemorySettingsStore) notify() {
+	for _, changes := range store.watchers {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSettingsStoresGetMissingPreset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stores := map[string]SettingsStore{
+		"directory": GetDirectorySettingsStore(filepath.Join(dir, "presets")),
+		"archive":   GetArchiveSettingsStore(filepath.Join(dir, "presets.zip")),
+		"memory":    GetMemorySettingsStore(),
+	}
+	for name, store := range stores {
+		id, err := store.Save(copySettings(&defaultSettings))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := store.Get(id); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, err := store.Get(id + 1); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: getting missing preset returned %v", name, err)
+		}
+		if err := store.Update(id + 1, copySettings(&defaultSettings)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: updating missing preset returned %v", name, err)
+		}
+	}
+}
+
+func TestSettingsStoresDontReuseDeletedIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stores := map[string]SettingsStore{
+		"directory": GetDirectorySettingsStore(filepath.Join(dir, "presets")),
+		"archive":   GetArchiveSettingsStore(filepath.Join(dir, "presets.zip")),
+		"memory":    GetMemorySettingsStore(),
+	}
+	for name, store := range stores {
+		id, err := store.Save(copySettings(&defaultSettings))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := store.Delete(id); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		nextID, err := store.Save(copySettings(&defaultSettings))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if nextID <= id {
+			t.Errorf("%s: deleted preset %d was followed by %d", name, id, nextID)
+		}
+	}
+}
+
+func TestArchiveSettingsStoreSkipsBadEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The second entry has wrong checksum, so it cannot be read.
+	path := filepath.Join(dir, "presets.zip")
+	data, err := encodeSettings(copySettings(&defaultSettings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	fileWriter, err := writer.Create(presetFilePrefix + "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write(data)
+	size := uint64(len(data))
+	fileWriter, err = writer.CreateRaw(&zip.FileHeader{Name: presetFilePrefix + "2", CRC32: 1, CompressedSize64: size, UncompressedSize64: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write(data)
+	writer.Close()
+	file.Close()
+
+	store := GetArchiveSettingsStore(path)
+	ids, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("archive lists presets %v, expected [1]", ids)
+	}
+	if _, err := store.Get(1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirectorySettingsStoreWatchesRewrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := GetDirectorySettingsStore(dir)
+	settings := copySettings(&defaultSettings)
+	id, err := store.Save(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, stop := store.Watch()
+
+	// Preset is rewritten in place, the set of presets stays the same.
+	settings.Metadata.Name = "Rewritten"
+	if err := store.Update(id, settings); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(3 * settingsWatchInterval):
+		t.Fatal("rewrite of preset wasn't noticed")
+	}
+
+	stop()
+	settings.Metadata.Name = "Rewritten again"
+	if err := store.Update(id, settings); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+		t.Error("stopped watcher signalled a change")
+	case <-time.After(2 * settingsWatchInterval):
+	}
+}
+
+func TestSyncSettingsReloadsRewrittenPresets(t *testing.T) {
+	previousStore, previousList := settingsStore, settingsList
+	defer func() {
+		settingsStore, settingsList = previousStore, previousList
+	}()
+
+	store := GetMemorySettingsStore()
+	settingsStore, settingsList = store, nil
+	for i := 0; i < 2; i++ {
+		if _, err := SaveSettings(copySettings(&defaultSettings)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another instance renames the second preset.
+	settings, err := store.Get(settingsList[1].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Metadata.Name = "Renamed"
+	if err := store.Update(settingsList[1].id, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, changed, added, err := SyncSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, "removed", removed, []int{})
+	expectValue(t, "changed", changed, []int{1})
+	expectValue(t, "added", added, 0)
+	expectValue(t, "Name", GetSettingsMetadata(1).Name, "Renamed")
+}
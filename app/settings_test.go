@@ -0,0 +1,38 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeSettingsClampsRating(t *testing.T) {
+	for rating, expected := range map[int]int{-3: 0, 2: 2, MaxRating + 1: MaxRating} {
+		data := []byte(fmt.Sprintf(`{"Version": %d, "Metadata": {"Rating": %d}}`, currentSettingsVersion, rating))
+		settings, _, err := decodeSettings(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectValue(t, "Rating", settings.Metadata.Rating, expected)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	for length, expected := range map[int]string{0: "", 1: "a", 2: "a", 3: "aé", 4: "aéb"} {
+		expectValue(t, fmt.Sprint("Truncated to ", length), truncateRunes("aéb", length), expected)
+	}
+}
+
+func TestCellOverrideDefaultScale(t *testing.T) {
+	data := []byte(fmt.Sprintf(`{"Version": %d, "Layers": [{"Overrides": [{"Index": 2, "Seed": 1, "Hidden": true}, {"Index": 3, "Scale": 0.5}]}]}`,
+		currentSettingsVersion))
+	settings, _, err := decodeSettings(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := settings.Layers[0].Overrides
+	if len(overrides) != 2 {
+		t.Fatalf("settings have %d overrides, expected 2", len(overrides))
+	}
+	expectValue(t, "Scale", overrides[0].Scale, 1.0)
+	expectValue(t, "Scale", overrides[1].Scale, 0.5)
+}
@@ -0,0 +1,508 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Share code is a short text form of settings, meant to be pasted into chats.
+// It's the prefix followed by base64 encoded binary payload:
+//
+//	version byte | fields | CRC32 of version and fields
+//
+// Every field starts with a tag byte. Scalars are stored as float32, count as uvarint, seed
+// as varint and colors as their number followed by 8-bit RGBA values. Distribution is stored
+// as its name and named parameters, scale distribution as its name and uniform flag, overlap settings
+// as avoid flag and minimum gap. Overrides of cells are stored as their number followed by index, seed,
+// flags (own color, hidden), scale and 8-bit RGBA color if it's set. Strings are
+// prefixed by their length. Preset metadata is not included, neither are paths of mesh and point-cloud
+// files, since they're local to the machine the code was made on.
+//
+// Fields of the whole scene come first, followed by fields of layers. Layers are separated by
+// layer tag, fields before the first layer tag belong to the first layer.
+const ShareCodePrefix = "iris:"
+
+// Version of share code format. It's increased whenever stored fields change, so older versions of the
+// app reject codes they would only decode partially.
+//   1: scalars, colors, seed, distribution, scale, shape, mesh and points paths
+//   2: overlap, overrides, symmetry, coloring and animation, mesh and points paths are no longer stored
+const shareCodeVersion = 2
+
+const (
+	shareCodeTagCount  = 0x40
+	shareCodeTagColors = 0x41
+	shareCodeTagSeed   = 0x42
+	shareCodeTagDistribution = 0x43
+	shareCodeTagScale  = 0x44
+	shareCodeTagShape  = 0x45
+	shareCodeTagMesh   = 0x46
+	shareCodeTagPoints = 0x47
+	shareCodeTagOverlap = 0x48
+	shareCodeTagOverrides = 0x49
+	shareCodeTagSymmetry = 0x4A
+	shareCodeTagColoring = 0x4B
+	shareCodeTagAnimation = 0x4C
+	shareCodeTagLayer  = 0x50
+)
+
+// shareCodeLayerFields maps tags of scalar fields of a layer to the settings they're stored in.
+// Tags must never be reused, new fields get new tags.
+var shareCodeLayerFields = []struct {
+	tag   byte
+	value func(settings *CellSettings) *float64
+}{
+	{0x01, func(s *CellSettings) *float64 { return &s.PolarStd }},
+	{0x02, func(s *CellSettings) *float64 { return &s.PolarMean }},
+	{0x03, func(s *CellSettings) *float64 { return &s.RadiusMin }},
+	{0x04, func(s *CellSettings) *float64 { return &s.RadiusMax }},
+	{0x05, func(s *CellSettings) *float64 { return &s.HeightRatio }},
+	{0x06, func(s *CellSettings) *float64 { return &s.Scale.WidthMin }},
+	{0x07, func(s *CellSettings) *float64 { return &s.Scale.WidthMax }},
+	{0x08, func(s *CellSettings) *float64 { return &s.Scale.DepthMin }},
+	{0x09, func(s *CellSettings) *float64 { return &s.Scale.DepthMax }},
+	{0x0A, func(s *CellSettings) *float64 { return &s.Shape.Bevel }},
+	{0x12, func(s *CellSettings) *float64 { return &s.Material.Roughness }},
+	{0x13, func(s *CellSettings) *float64 { return &s.Material.Reflectivity }},
+}
+
+// shareCodeFields maps tags of scalar fields of the whole scene to the settings they're stored in.
+var shareCodeFields = []struct {
+	tag   byte
+	value func(settings *AppSettings) *float64
+}{
+	{0x10, func(s *AppSettings) *float64 { return &s.Rendering.DirectLight }},
+	{0x11, func(s *AppSettings) *float64 { return &s.Rendering.AmbientLight }},
+	{0x14, func(s *AppSettings) *float64 { return &s.Rendering.SSAORadius }},
+	{0x15, func(s *AppSettings) *float64 { return &s.Rendering.SSAORange }},
+	{0x16, func(s *AppSettings) *float64 { return &s.Rendering.SSAOBoundary }},
+	{0x17, func(s *AppSettings) *float64 { return &s.Rendering.MinWhite }},
+	{0x20, func(s *AppSettings) *float64 { return &s.Camera.Radius }},
+	{0x21, func(s *AppSettings) *float64 { return &s.Camera.Azimuth }},
+	{0x22, func(s *AppSettings) *float64 { return &s.Camera.Polar }},
+	{0x23, func(s *AppSettings) *float64 { return &s.Camera.Height }},
+}
+
+// EncodeShareCode turns settings into a share code.
+func EncodeShareCode(settings AppSettings) string {
+	var buffer bytes.Buffer
+	buffer.WriteByte(shareCodeVersion)
+	for _, field := range shareCodeFields {
+		buffer.WriteByte(field.tag)
+		binary.Write(&buffer, binary.LittleEndian, float32(*field.value(&settings)))
+	}
+	for i := range settings.Layers {
+		if i > 0 {
+			buffer.WriteByte(shareCodeTagLayer)
+		}
+		writeShareCodeLayer(&buffer, &settings.Layers[i])
+	}
+
+	binary.Write(&buffer, binary.BigEndian, crc32.ChecksumIEEE(buffer.Bytes()))
+	return ShareCodePrefix + base64.RawURLEncoding.EncodeToString(buffer.Bytes())
+}
+
+// DecodeShareCode parses share code back into settings. Values missing from
+// the code are taken from default settings.
+func DecodeShareCode(code string) (AppSettings, error) {
+	settings := copySettings(&defaultSettings)
+
+	// Codes pasted from chats tend to be surrounded by whitespace.
+	code = strings.TrimSpace(code)
+	if !strings.HasPrefix(code, ShareCodePrefix) {
+		return settings, errors.New("not a share code")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(code, ShareCodePrefix))
+	if err != nil {
+		return settings, fmt.Errorf("invalid share code: %v", err)
+	}
+	if len(payload) < 1+crc32.Size {
+		return settings, errors.New("share code is too short")
+	}
+	data, checksum := payload[:len(payload)-crc32.Size], payload[len(payload)-crc32.Size:]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(checksum) {
+		return settings, errors.New("share code is damaged, checksum doesn't match")
+	}
+	if data[0] > shareCodeVersion {
+		return settings, fmt.Errorf("share code version %d is newer than supported version %d", data[0], shareCodeVersion)
+	}
+
+	reader := bytes.NewReader(data[1:])
+	layer := &settings.Layers[0]
+	for reader.Len() > 0 {
+		tag, _ := reader.ReadByte()
+		if tag == shareCodeTagLayer {
+			if len(settings.Layers) >= MaxLayers {
+				return settings, errors.New("too many layers in share code")
+			}
+			settings.Layers = append(settings.Layers, CopyCellSettings(defaultCellSettings))
+			layer = &settings.Layers[len(settings.Layers) - 1]
+			continue
+		}
+		err = readShareCodeField(reader, tag, &settings, layer)
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	err = validateSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+func writeShareCodeLayer(buffer *bytes.Buffer, layer *CellSettings) {
+	for _, field := range shareCodeLayerFields {
+		buffer.WriteByte(field.tag)
+		binary.Write(buffer, binary.LittleEndian, float32(*field.value(layer)))
+	}
+
+	var varint [binary.MaxVarintLen64]byte
+	buffer.WriteByte(shareCodeTagCount)
+	buffer.Write(varint[:binary.PutUvarint(varint[:], uint64(layer.Count))])
+
+	buffer.WriteByte(shareCodeTagSeed)
+	buffer.Write(varint[:binary.PutVarint(varint[:], layer.Seed)])
+
+	buffer.WriteByte(shareCodeTagDistribution)
+	writeShareCodeString(buffer, layer.Distribution.Name)
+	writeShareCodeParameters(buffer, layer.Distribution.Parameters)
+
+	buffer.WriteByte(shareCodeTagColoring)
+	writeShareCodeString(buffer, layer.Coloring.Name)
+	writeShareCodeParameters(buffer, layer.Coloring.Parameters)
+
+	buffer.WriteByte(shareCodeTagScale)
+	writeShareCodeString(buffer, layer.Scale.Distribution)
+	uniform := byte(0)
+	if layer.Scale.Uniform {
+		uniform = 1
+	}
+	buffer.WriteByte(uniform)
+
+	buffer.WriteByte(shareCodeTagShape)
+	writeShareCodeString(buffer, layer.Shape.Name)
+
+	buffer.WriteByte(shareCodeTagOverlap)
+	avoid := byte(0)
+	if layer.Overlap.Avoid {
+		avoid = 1
+	}
+	buffer.WriteByte(avoid)
+	binary.Write(buffer, binary.LittleEndian, float32(layer.Overlap.MinGap))
+
+	buffer.WriteByte(shareCodeTagSymmetry)
+	buffer.Write(varint[:binary.PutUvarint(varint[:], uint64(layer.Symmetry.Fold))])
+	mirrors := byte(0)
+	for i, mirror := range []bool{layer.Symmetry.MirrorX, layer.Symmetry.MirrorY, layer.Symmetry.MirrorZ} {
+		if mirror {
+			mirrors |= 1 << uint(i)
+		}
+	}
+	buffer.WriteByte(mirrors)
+
+	if len(layer.Overrides) > 0 {
+		buffer.WriteByte(shareCodeTagOverrides)
+		buffer.Write(varint[:binary.PutUvarint(varint[:], uint64(len(layer.Overrides)))])
+		for _, override := range layer.Overrides {
+			buffer.Write(varint[:binary.PutUvarint(varint[:], uint64(override.Index))])
+			buffer.Write(varint[:binary.PutVarint(varint[:], override.Seed)])
+			flags := byte(0)
+			if override.HasColor {
+				flags |= 1
+			}
+			if override.Hidden {
+				flags |= 2
+			}
+			buffer.WriteByte(flags)
+			binary.Write(buffer, binary.LittleEndian, float32(override.Scale))
+			if override.HasColor {
+				writeShareCodeColor(buffer, override.Color)
+			}
+		}
+	}
+
+	// Animation is skipped when cells don't move, to keep codes short.
+	if IsAnimated(&layer.Animation) {
+		buffer.WriteByte(shareCodeTagAnimation)
+		for _, motion := range getAnimationMotions(&layer.Animation) {
+			binary.Write(buffer, binary.LittleEndian, float32(motion.Speed))
+			binary.Write(buffer, binary.LittleEndian, float32(motion.Amplitude))
+		}
+	}
+
+	buffer.WriteByte(shareCodeTagColors)
+	buffer.WriteByte(byte(len(layer.Colors)))
+	for _, color := range layer.Colors {
+		writeShareCodeColor(buffer, color)
+	}
+}
+
+// writeShareCodeParameters writes parameters sorted by their names, so the same parameters always
+// give the same code. At most 255 parameters are written.
+func writeShareCodeParameters(buffer *bytes.Buffer, parameters map[string]float64) {
+	names := getSortedParameterNames(parameters)
+	if len(names) > math.MaxUint8 {
+		names = names[:math.MaxUint8]
+	}
+	buffer.WriteByte(byte(len(names)))
+	for _, name := range names {
+		writeShareCodeString(buffer, name)
+		binary.Write(buffer, binary.LittleEndian, float32(parameters[name]))
+	}
+}
+
+func readShareCodeParameters(reader *bytes.Reader) (map[string]float64, error) {
+	count, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	parameters := make(map[string]float64, count)
+	for i := 0; i < int(count); i++ {
+		name, err := readShareCodeString(reader)
+		var value float32
+		if err == nil {
+			err = binary.Read(reader, binary.LittleEndian, &value)
+		}
+		if err == nil && (math.IsNaN(float64(value)) || math.IsInf(float64(value), 0)) {
+			err = errors.New("invalid value")
+		}
+		if err != nil {
+			return nil, err
+		}
+		parameters[name] = float64(value)
+	}
+	return parameters, nil
+}
+
+func writeShareCodeColor(buffer *bytes.Buffer, color mgl32.Vec4) {
+	for _, component := range color {
+		buffer.WriteByte(byte(math.Round(float64(mgl32.Clamp(component, 0, 1)) * 255)))
+	}
+}
+
+func readShareCodeColor(reader *bytes.Reader) (mgl32.Vec4, error) {
+	var components [4]byte
+	var color mgl32.Vec4
+	_, err := io.ReadFull(reader, components[:])
+	for c := range components {
+		color[c] = float32(components[c]) / 255.0
+	}
+	return color, err
+}
+
+func writeShareCodeString(buffer *bytes.Buffer, text string) {
+	if len(text) > math.MaxUint8 {
+		text = text[:math.MaxUint8]
+	}
+	buffer.WriteByte(byte(len(text)))
+	buffer.WriteString(text)
+}
+
+func readShareCodeString(reader *bytes.Reader) (string, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	text := make([]byte, length)
+	_, err = io.ReadFull(reader, text)
+	return string(text), err
+}
+
+// readShareCodeField reads value of field with tag into settings, fields of a layer are read into layer.
+func readShareCodeField(reader *bytes.Reader, tag byte, settings *AppSettings, layer *CellSettings) error {
+	switch tag {
+	case shareCodeTagCount:
+		count, err := binary.ReadUvarint(reader)
+		if err != nil || count > math.MaxInt32 {
+			return errors.New("invalid cell count in share code")
+		}
+		layer.Count = int(count)
+		return nil
+	case shareCodeTagSeed:
+		seed, err := binary.ReadVarint(reader)
+		if err != nil {
+			return errors.New("invalid seed in share code")
+		}
+		layer.Seed = seed
+		return nil
+	case shareCodeTagDistribution:
+		name, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid distribution in share code")
+		}
+		parameters, err := readShareCodeParameters(reader)
+		if err != nil {
+			return errors.New("invalid distribution parameter in share code")
+		}
+		layer.Distribution = DistributionSettings{name, parameters}
+		return nil
+	case shareCodeTagAnimation:
+		for _, motion := range getAnimationMotions(&layer.Animation) {
+			var values [2]float32
+			err := binary.Read(reader, binary.LittleEndian, &values)
+			for _, value := range values {
+				if err == nil && (math.IsNaN(float64(value)) || math.IsInf(float64(value), 0)) {
+					err = errors.New("invalid value")
+				}
+			}
+			if err != nil {
+				return errors.New("invalid animation in share code")
+			}
+			*motion = AnimationMotion{float64(values[0]), float64(values[1])}
+		}
+		return nil
+	case shareCodeTagColoring:
+		name, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid coloring in share code")
+		}
+		parameters, err := readShareCodeParameters(reader)
+		if err != nil {
+			return errors.New("invalid coloring parameter in share code")
+		}
+		layer.Coloring = ColoringSettings{name, parameters}
+		return nil
+	case shareCodeTagScale:
+		name, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid scale in share code")
+		}
+		uniform, err := reader.ReadByte()
+		if err != nil {
+			return errors.New("invalid scale in share code")
+		}
+		layer.Scale.Distribution = name
+		layer.Scale.Uniform = uniform != 0
+		return nil
+	case shareCodeTagShape:
+		name, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid shape in share code")
+		}
+		layer.Shape.Name = name
+		return nil
+	// Codes of version 1 contain paths to files on machine they were made on. They're read
+	// only to be skipped, files of the same name on this machine can be anything.
+	case shareCodeTagMesh:
+		path, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid mesh in share code")
+		}
+		log.Printf("share code: ignoring mesh %q, files aren't shared", path)
+		return nil
+	case shareCodeTagPoints:
+		path, err := readShareCodeString(reader)
+		if err != nil {
+			return errors.New("invalid points in share code")
+		}
+		if _, err := binary.ReadUvarint(reader); err != nil {
+			return errors.New("invalid points in share code")
+		}
+		log.Printf("share code: ignoring points %q, files aren't shared", path)
+		return nil
+	case shareCodeTagOverlap:
+		avoid, err := reader.ReadByte()
+		var minGap float32
+		if err == nil {
+			err = binary.Read(reader, binary.LittleEndian, &minGap)
+		}
+		if err != nil || math.IsNaN(float64(minGap)) || math.IsInf(float64(minGap), 0) {
+			return errors.New("invalid overlap settings in share code")
+		}
+		layer.Overlap = OverlapSettings{avoid != 0, float64(minGap)}
+		return nil
+	case shareCodeTagSymmetry:
+		fold, err := binary.ReadUvarint(reader)
+		var mirrors byte
+		if err == nil {
+			mirrors, err = reader.ReadByte()
+		}
+		if err != nil || fold < 1 || fold > MaxSymmetryFold {
+			return errors.New("invalid symmetry settings in share code")
+		}
+		layer.Symmetry = SymmetrySettings{int(fold), mirrors & 1 != 0, mirrors & 2 != 0, mirrors & 4 != 0}
+		return nil
+	case shareCodeTagOverrides:
+		// Every override takes at least 7 bytes, so corrupt count doesn't allocate too much.
+		count, err := binary.ReadUvarint(reader)
+		if err != nil || count > uint64(reader.Len()) / 7 {
+			return errors.New("invalid cell overrides in share code")
+		}
+		layer.Overrides = make([]CellOverride, count)
+		for i := range layer.Overrides {
+			override := &layer.Overrides[i]
+			index, err := binary.ReadUvarint(reader)
+			if err == nil && index > math.MaxInt32 {
+				err = errors.New("invalid index")
+			}
+			override.Index = int(index)
+			if err == nil {
+				override.Seed, err = binary.ReadVarint(reader)
+			}
+			var flags byte
+			if err == nil {
+				flags, err = reader.ReadByte()
+			}
+			var scale float32
+			if err == nil {
+				err = binary.Read(reader, binary.LittleEndian, &scale)
+			}
+			override.Scale = float64(scale)
+			override.HasColor, override.Hidden = flags & 1 != 0, flags & 2 != 0
+			if err == nil && override.HasColor {
+				override.Color, err = readShareCodeColor(reader)
+			}
+			if err != nil || math.IsNaN(float64(scale)) || math.IsInf(float64(scale), 0) {
+				return errors.New("invalid cell overrides in share code")
+			}
+		}
+		return nil
+	case shareCodeTagColors:
+		count, err := reader.ReadByte()
+		if err != nil || count > MaxPaletteSize {
+			return errors.New("invalid colors in share code")
+		}
+		layer.Colors = make([]mgl32.Vec4, count)
+		for i := range layer.Colors {
+			layer.Colors[i], err = readShareCodeColor(reader)
+			if err != nil {
+				return errors.New("invalid colors in share code")
+			}
+		}
+		return nil
+	}
+
+	var value *float64
+	for _, field := range shareCodeFields {
+		if field.tag == tag {
+			value = field.value(settings)
+		}
+	}
+	for _, field := range shareCodeLayerFields {
+		if field.tag == tag {
+			value = field.value(layer)
+		}
+	}
+	if value == nil {
+		return fmt.Errorf("unknown field %#x in share code", tag)
+	}
+
+	var storedValue float32
+	err := binary.Read(reader, binary.LittleEndian, &storedValue)
+	if err != nil || math.IsNaN(float64(storedValue)) || math.IsInf(float64(storedValue), 0) {
+		return fmt.Errorf("invalid value of field %#x in share code", tag)
+	}
+	*value = float64(storedValue)
+	return nil
+}
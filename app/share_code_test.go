@@ -0,0 +1,58 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestShareCodeRoundTrip(t *testing.T) {
+	settings := copySettings(&defaultSettings)
+	layer := &settings.Layers[0]
+	layer.Count = 1234
+	layer.Seed = -42
+	layer.Overlap = OverlapSettings{true, 0.25}
+	layer.Symmetry = SymmetrySettings{3, true, false, true}
+	layer.Coloring = ColoringSettings{"noise", map[string]float64{"Frequency": 0.5}}
+	layer.Animation.Orbit = AnimationMotion{0.25, 0.5}
+	layer.Shape.Mesh = "/home/someone/cell.obj"
+	layer.Points = PointsSettings{"/home/someone/cells.csv", 1}
+
+	decoded, err := DecodeShareCode(EncodeShareCode(settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedLayer := &decoded.Layers[0]
+	expectValue(t, "Count", decodedLayer.Count, 1234)
+	expectValue(t, "Seed", decodedLayer.Seed, int64(-42))
+	expectValue(t, "Overlap", decodedLayer.Overlap, layer.Overlap)
+	expectValue(t, "Symmetry", decodedLayer.Symmetry, layer.Symmetry)
+	expectValue(t, "Coloring", decodedLayer.Coloring, layer.Coloring)
+	expectValue(t, "Animation", decodedLayer.Animation, layer.Animation)
+	// Paths to files are local to the machine, so they aren't shared.
+	expectValue(t, "Mesh", decodedLayer.Shape.Mesh, "")
+	expectValue(t, "Points", decodedLayer.Points, PointsSettings{})
+}
+
+func TestShareCodeIgnoresPathsOfVersion1(t *testing.T) {
+	var buffer bytes.Buffer
+	buffer.WriteByte(1)
+	buffer.WriteByte(shareCodeTagCount)
+	buffer.WriteByte(100)
+	buffer.WriteByte(shareCodeTagMesh)
+	writeShareCodeString(&buffer, "/home/someone/cell.obj")
+	buffer.WriteByte(shareCodeTagPoints)
+	writeShareCodeString(&buffer, "/home/someone/cells.csv")
+	buffer.WriteByte(2)
+	binary.Write(&buffer, binary.BigEndian, crc32.ChecksumIEEE(buffer.Bytes()))
+
+	settings, err := DecodeShareCode(ShareCodePrefix + base64.RawURLEncoding.EncodeToString(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, "Count", settings.Layers[0].Count, 100)
+	expectValue(t, "Mesh", settings.Layers[0].Shape.Mesh, "")
+	expectValue(t, "Points", settings.Layers[0].Points, PointsSettings{})
+}
@@ -0,0 +1,124 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Number of floats per vertex - position (x, y, z, 1) followed by normal (x, y, z, 0).
+const VertexSize = 8
+
+// VertexAttribs are sizes of vertex attributes of MeshData, as expected by graphics.GetMesh.
+var VertexAttribs = []int{4, 4}
+
+// MeshData holds vertices and triangle indices of a mesh, ready to be passed to graphics.GetMesh.
+// Triangles are wound clockwise when seen from outside of the mesh.
+type MeshData struct {
+	Vertices []float32
+	Indices  []uint32
+}
+
+// VertexCount returns number of vertices in mesh.
+func (mesh *MeshData) VertexCount() int {
+	return len(mesh.Vertices) / VertexSize
+}
+
+// Position returns position of vertex at index.
+func (mesh *MeshData) Position(index uint32) mgl32.Vec3 {
+	vertex := mesh.Vertices[int(index) * VertexSize:]
+	return mgl32.Vec3{vertex[0], vertex[1], vertex[2]}
+}
+
+// Normal returns normal of vertex at index.
+func (mesh *MeshData) Normal(index uint32) mgl32.Vec3 {
+	vertex := mesh.Vertices[int(index) * VertexSize:]
+	return mgl32.Vec3{vertex[4], vertex[5], vertex[6]}
+}
+
+func (mesh *MeshData) addVertex(position, normal mgl32.Vec3) uint32 {
+	mesh.Vertices = append(mesh.Vertices,
+		position[0], position[1], position[2], 1.0,
+		normal[0], normal[1], normal[2], 0.0)
+	return uint32(mesh.VertexCount() - 1)
+}
+
+// addTriangle adds triangle of vertices a, b and c. Its winding is fixed using vertex normals,
+// so generators don't have to care about the order of vertices.
+func (mesh *MeshData) addTriangle(a, b, c uint32) {
+	positionA, positionB, positionC := mesh.Position(a), mesh.Position(b), mesh.Position(c)
+	outwards := mesh.Normal(a).Add(mesh.Normal(b)).Add(mesh.Normal(c))
+	if positionB.Sub(positionA).Cross(positionC.Sub(positionA)).Dot(outwards) > 0 {
+		b, c = c, b
+	}
+	mesh.Indices = append(mesh.Indices, a, b, c)
+}
+
+// addQuad adds quad of vertices a, b, c and d, which go around its perimeter.
+func (mesh *MeshData) addQuad(a, b, c, d uint32) {
+	mesh.addTriangle(a, b, c)
+	mesh.addTriangle(a, c, d)
+}
+
+// addGrid connects grid of vertices with quads. Grid has rows x columns vertices stored
+// row by row, starting at vertex first.
+func (mesh *MeshData) addGrid(first uint32, rows, columns int) {
+	for row := 0; row < rows - 1; row++ {
+		for column := 0; column < columns - 1; column++ {
+			a := first + uint32(row * columns + column)
+			b := a + 1
+			c := b + uint32(columns)
+			d := a + uint32(columns)
+			mesh.addQuad(a, b, c, d)
+		}
+	}
+}
+
+// ComputeNormals sets normal of every vertex to the area weighted average of normals of triangles
+// it's part of. Triangles are expected to be wound clockwise when seen from outside.
+func (mesh *MeshData) ComputeNormals() {
+	normals := make([]mgl32.Vec3, mesh.VertexCount())
+	for i := 0; i + 2 < len(mesh.Indices); i += 3 {
+		a, b, c := mesh.Indices[i], mesh.Indices[i + 1], mesh.Indices[i + 2]
+		positionA := mesh.Position(a)
+		// Clockwise winding makes the cross product point inwards.
+		normal := mesh.Position(c).Sub(positionA).Cross(mesh.Position(b).Sub(positionA))
+		for _, index := range []uint32{a, b, c} {
+			normals[index] = normals[index].Add(normal)
+		}
+	}
+	for i, normal := range normals {
+		if normal.Len() > 0 {
+			normal = normal.Normalize()
+		}
+		copy(mesh.Vertices[i * VertexSize + 4:], normal[:])
+	}
+}
+
+// NormalizeBounds moves and uniformly scales mesh, so its bounding box is centered at the origin
+// and its longest side is 1.
+func (mesh *MeshData) NormalizeBounds() {
+	if mesh.VertexCount() == 0 {
+		return
+	}
+	min, max := mesh.Position(0), mesh.Position(0)
+	for i := 1; i < mesh.VertexCount(); i++ {
+		position := mesh.Position(uint32(i))
+		for axis := range position {
+			min[axis] = float32(math.Min(float64(min[axis]), float64(position[axis])))
+			max[axis] = float32(math.Max(float64(max[axis]), float64(position[axis])))
+		}
+	}
+	center := min.Add(max).Mul(0.5)
+	size := max.Sub(min)
+	scale := float32(math.Max(float64(size[0]), math.Max(float64(size[1]), float64(size[2]))))
+	if scale == 0 {
+		scale = 1
+	}
+	for i := 0; i < mesh.VertexCount(); i++ {
+		vertex := mesh.Vertices[i * VertexSize:]
+		for axis := 0; axis < 3; axis++ {
+			vertex[axis] = (vertex[axis] - center[axis]) / scale
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package geometry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Maximum number of vertices of a loaded mesh, indices are 32-bit.
+const maxLoadedVertices = 1 << 24
+
+// LoadMesh loads mesh from Wavefront OBJ or PLY file, selected by the file's extension.
+// Loaded mesh is normalized, so it fits into the box [-0.5, 0.5]^3 like the primitives do.
+func LoadMesh(path string) (MeshData, error) {
+	extension := strings.ToLower(filepath.Ext(path))
+	if extension != ".obj" && extension != ".ply" {
+		return MeshData{}, fmt.Errorf("%s: unsupported mesh format, only .obj and .ply files are supported", filepath.Base(path))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return MeshData{}, err
+	}
+	defer file.Close()
+
+	var mesh MeshData
+	if extension == ".obj" {
+		mesh, err = ReadOBJ(file)
+	} else {
+		mesh, err = ReadPLY(file)
+	}
+	if err != nil {
+		return MeshData{}, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return mesh, nil
+}
+
+// meshBuilder collects polygons of a loaded mesh. Vertices are shared by polygons using
+// the same position and normal.
+type meshBuilder struct {
+	positions []mgl32.Vec3
+	normals   []mgl32.Vec3
+	vertices  map[[2]int]uint32
+	// Position and normal index of every vertex of mesh.
+	keys      [][2]int
+	mesh      MeshData
+	// Whether all vertices have normals, they're computed from polygons otherwise.
+	hasNormals bool
+}
+
+func getMeshBuilder() meshBuilder {
+	return meshBuilder{vertices: make(map[[2]int]uint32), hasNormals: true}
+}
+
+// addPolygon adds polygon with corners given by indices of positions and normals. Normal index is -1
+// for corners without normal. Polygons are triangulated as fans, so they're expected to be convex.
+// Loaded files wind polygons counter-clockwise, the opposite of MeshData.
+func (builder *meshBuilder) addPolygon(positions, normals []int) error {
+	if len(positions) < 3 {
+		return errors.New("face has less than 3 vertices")
+	}
+	corners := make([]uint32, len(positions))
+	for i, position := range positions {
+		if position < 0 || position >= len(builder.positions) {
+			return fmt.Errorf("face refers to vertex %d, but there are only %d vertices", position + 1, len(builder.positions))
+		}
+		normal := normals[i]
+		if normal >= len(builder.normals) {
+			return fmt.Errorf("face refers to normal %d, but there are only %d normals", normal + 1, len(builder.normals))
+		}
+		if normal < 0 {
+			builder.hasNormals = false
+		}
+		key := [2]int{position, normal}
+		vertex, ok := builder.vertices[key]
+		if !ok {
+			if builder.mesh.VertexCount() >= maxLoadedVertices {
+				return fmt.Errorf("mesh has more than %d vertices", maxLoadedVertices)
+			}
+			var normalValue mgl32.Vec3
+			if normal >= 0 {
+				normalValue = builder.normals[normal]
+			}
+			vertex = builder.mesh.addVertex(builder.positions[position], normalValue)
+			builder.vertices[key] = vertex
+			builder.keys = append(builder.keys, key)
+		}
+		corners[i] = vertex
+	}
+	for i := 1; i < len(corners) - 1; i++ {
+		builder.mesh.Indices = append(builder.mesh.Indices, corners[0], corners[i + 1], corners[i])
+	}
+	return nil
+}
+
+// finish returns the built mesh with normals computed if any were missing, normalized to unit bounds.
+func (builder *meshBuilder) finish() (MeshData, error) {
+	if len(builder.mesh.Indices) == 0 {
+		return MeshData{}, errors.New("mesh has no faces")
+	}
+	for _, value := range builder.mesh.Vertices {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return MeshData{}, errors.New("mesh has invalid coordinates")
+		}
+	}
+	mesh := builder.mesh
+	if !builder.hasNormals {
+		// Vertices were split by normal indices, which are useless now.
+		mesh = MeshData{}
+		remap := make([]uint32, len(builder.keys))
+		positions := make(map[int]uint32)
+		for vertex, key := range builder.keys {
+			shared, ok := positions[key[0]]
+			if !ok {
+				shared = mesh.addVertex(builder.positions[key[0]], mgl32.Vec3{})
+				positions[key[0]] = shared
+			}
+			remap[vertex] = shared
+		}
+		mesh.Indices = make([]uint32, len(builder.mesh.Indices))
+		for i, index := range builder.mesh.Indices {
+			mesh.Indices[i] = remap[index]
+		}
+		mesh.ComputeNormals()
+	} else {
+		for i := 0; i < mesh.VertexCount(); i++ {
+			normal := mesh.Normal(uint32(i))
+			if normal.Len() > 0 {
+				normal = normal.Normalize()
+			}
+			copy(mesh.Vertices[i * VertexSize + 4:], normal[:])
+		}
+	}
+	mesh.NormalizeBounds()
+	return mesh, nil
+}
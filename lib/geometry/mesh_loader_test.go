@@ -0,0 +1,43 @@
+package geometry
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadOBJRejectsIndicesOutOfRange(t *testing.T) {
+	const vertices = "v 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\n"
+	for _, face := range []string{"f 1 2 4", "f -4 -2 -1", "f 1//1 2//1 3//2", "f 1//-2 2//-1 3//-1"} {
+		if _, err := ReadOBJ(strings.NewReader(vertices + face + "\n")); err == nil {
+			t.Errorf("face %q was accepted", face)
+		}
+	}
+	if _, err := ReadOBJ(strings.NewReader(vertices + "f -3//-1 -2//-1 -1//-1\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadPLYWithTruncatedVertices(t *testing.T) {
+	// Header claims far more vertices than there are, reading must fail without allocating all of them.
+	data := "ply\nformat ascii 1.0\nelement vertex 67108864\nproperty float x\nproperty float y\nproperty float z\n" +
+		"element face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0\n1 0 0\n"
+	if _, err := ReadPLY(strings.NewReader(data)); err == nil {
+		t.Error("truncated PLY file was accepted")
+	}
+}
+
+func TestReadPLYElementWithTruncatedElements(t *testing.T) {
+	// Header claims far more vertices than there are, reading must fail without allocating all of them.
+	data := "ply\nformat ascii 1.0\nelement vertex 67108864\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property uchar layer\nend_header\n0 0 0 1\n1 0 0 1\n"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := ReadPLYElement(strings.NewReader(data), "vertex"); err == nil {
+		t.Error("truncated PLY file was accepted")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1 << 20 {
+		t.Errorf("reading truncated PLY file allocated %d bytes", allocated)
+	}
+}
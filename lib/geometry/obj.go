@@ -0,0 +1,139 @@
+package geometry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Statements of free-form geometry in OBJ files. Only polygonal meshes are supported.
+var objFreeFormStatements = map[string]bool{
+	"vp": true, "cstype": true, "deg": true, "bmat": true, "step": true,
+	"curv": true, "curv2": true, "surf": true, "parm": true, "trim": true,
+	"hole": true, "scrv": true, "sp": true, "end": true, "con": true,
+}
+
+// ReadOBJ reads polygonal mesh from Wavefront OBJ data. Texture coordinates, groups and materials
+// are ignored, so are lines and points, since they have no surface.
+func ReadOBJ(reader io.Reader) (MeshData, error) {
+	builder := getMeshBuilder()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64 * 1024), 16 * 1024 * 1024)
+	lineNumber := 0
+	var positions, normals []int
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		// Long statements can continue on the next line.
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			lineNumber++
+			line = line[:len(line) - 1] + " " + scanner.Text()
+		}
+		if comment := strings.IndexByte(line, '#'); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch statement := fields[0]; {
+		case statement == "v":
+			var position mgl32.Vec3
+			position, err = parseOBJVector(fields[1:], true)
+			builder.positions = append(builder.positions, position)
+		case statement == "vn":
+			var normal mgl32.Vec3
+			normal, err = parseOBJVector(fields[1:], false)
+			builder.normals = append(builder.normals, normal)
+		case statement == "f" || statement == "fo":
+			positions, normals = positions[:0], normals[:0]
+			for _, corner := range fields[1:] {
+				var position, normal int
+				position, normal, err = parseOBJCorner(corner, len(builder.positions), len(builder.normals))
+				if err != nil {
+					break
+				}
+				positions = append(positions, position)
+				normals = append(normals, normal)
+			}
+			if err == nil {
+				err = builder.addPolygon(positions, normals)
+			}
+		case objFreeFormStatements[statement]:
+			err = fmt.Errorf("free-form geometry (%s) is not supported, only polygons are", statement)
+		}
+		if err != nil {
+			return MeshData{}, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return MeshData{}, err
+	}
+	return builder.finish()
+}
+
+// parseOBJVector parses coordinates of a vector. Positions can have an additional weight,
+// they're divided by it.
+func parseOBJVector(fields []string, weighted bool) (mgl32.Vec3, error) {
+	var vector mgl32.Vec3
+	if len(fields) < 3 {
+		return vector, fmt.Errorf("vector has %d coordinates, 3 are needed", len(fields))
+	}
+	for i := range vector {
+		value, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil {
+			return vector, fmt.Errorf("invalid coordinate %q", fields[i])
+		}
+		vector[i] = float32(value)
+	}
+	if weighted && len(fields) > 3 {
+		weight, err := strconv.ParseFloat(fields[3], 32)
+		if err != nil || weight == 0 {
+			return vector, fmt.Errorf("invalid weight %q", fields[3])
+		}
+		vector = vector.Mul(float32(1.0 / weight))
+	}
+	return vector, nil
+}
+
+// parseOBJCorner parses corner of a face in format position[/texture[/normal]]. It returns zero based
+// indices of position and normal, normal is -1 if it's missing. Negative indices count from the last
+// position or normal read so far.
+func parseOBJCorner(corner string, positionCount, normalCount int) (int, int, error) {
+	parts := strings.Split(corner, "/")
+	position, err := parseOBJIndex(parts[0], positionCount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vertex %q: %v", corner, err)
+	}
+	normal := -1
+	if len(parts) > 2 && parts[2] != "" {
+		normal, err = parseOBJIndex(parts[2], normalCount)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid normal of vertex %q: %v", corner, err)
+		}
+	}
+	return position, normal, nil
+}
+
+// parseOBJIndex parses one based index, or negative index relative to count elements read so far.
+// Negative indices reaching before the first element are out of range, positive ones are checked
+// only once the whole face is read.
+func parseOBJIndex(text string, count int) (int, error) {
+	index, err := strconv.Atoi(text)
+	if err != nil || index == 0 {
+		return 0, fmt.Errorf("invalid index %q", text)
+	}
+	if index < 0 {
+		if index < -count {
+			return 0, fmt.Errorf("index %d is out of range, only %d were read", index, count)
+		}
+		return count + index, nil
+	}
+	return index - 1, nil
+}
@@ -0,0 +1,409 @@
+package geometry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Maximum number of elements of a single kind in PLY file, to avoid huge allocations for corrupt files.
+const maxPLYElements = 1 << 26
+
+// plyProperty describes a property of PLY element. List properties have type of their length
+// in countType.
+type plyProperty struct {
+	name      string
+	valueType string
+	countType string
+}
+
+// plyElement describes an element of PLY file and its properties.
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// Sizes of PLY types in bytes.
+var plyTypeSizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// plyValueReader reads values of PLY elements, either as text or binary.
+type plyValueReader interface {
+	read(valueType string) (float64, error)
+}
+
+// ReadPLY reads mesh from ASCII or binary PLY data. Vertices need x, y and z properties,
+// normals are read from nx, ny and nz if all of them are present. Faces are read from
+// vertex_indices (or vertex_index) list. Other elements and properties are skipped.
+func ReadPLY(reader io.Reader) (MeshData, error) {
+	buffered := bufio.NewReader(reader)
+	format, elements, err := readPLYHeader(buffered)
+	if err != nil {
+		return MeshData{}, err
+	}
+
+	values, err := getPLYValueReader(buffered, format)
+	if err != nil {
+		return MeshData{}, err
+	}
+
+	builder := getMeshBuilder()
+	hasVertices := false
+	var positions, normals []int
+	for _, element := range elements {
+		switch element.name {
+		case "vertex":
+			hasVertices = true
+			err = readPLYVertices(values, element, &builder)
+		case "face":
+			if !hasVertices {
+				return MeshData{}, errors.New("faces of PLY file come before its vertices")
+			}
+			for i := 0; i < element.count && err == nil; i++ {
+				positions, err = readPLYFace(values, element, positions[:0])
+				if err == nil {
+					normals = normals[:0]
+					for range positions {
+						normals = append(normals, -1)
+					}
+					if len(builder.normals) > 0 {
+						// Normals are stored with vertices, so their indices are the same.
+						copy(normals, positions)
+					}
+					err = builder.addPolygon(positions, normals)
+				}
+				if err != nil {
+					err = fmt.Errorf("face %d: %v", i + 1, err)
+				}
+			}
+		default:
+			err = skipPLYElement(values, element)
+		}
+		if err != nil {
+			return MeshData{}, err
+		}
+	}
+	return builder.finish()
+}
+
+// PLYProperty holds values of a scalar property of PLY elements, along with its type as written in the file.
+type PLYProperty struct {
+	Type   string
+	Values []float64
+}
+
+// IsInteger reports whether the property has integer type.
+func (property *PLYProperty) IsInteger() bool {
+	return property.Type != "float" && property.Type != "float32" && property.Type != "double" && property.Type != "float64"
+}
+
+// ReadPLYElement reads scalar properties of all elements with name from PLY data, e.g. vertices
+// of a point cloud. Properties are returned by name, list properties and other elements are skipped.
+func ReadPLYElement(reader io.Reader, name string) (map[string]PLYProperty, error) {
+	buffered := bufio.NewReader(reader)
+	format, elements, err := readPLYHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	values, err := getPLYValueReader(buffered, format)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, element := range elements {
+		if element.name != name {
+			err = skipPLYElement(values, element)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Values are appended as they're read, count in the header could be far larger than the data.
+		columns := make([][]float64, len(element.properties))
+		for i := 0; i < element.count; i++ {
+			for j, property := range element.properties {
+				if property.countType != "" {
+					err = skipPLYList(values, property)
+				} else {
+					var value float64
+					value, err = values.read(property.valueType)
+					columns[j] = append(columns[j], value)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s %d: %v", name, i + 1, err)
+				}
+			}
+		}
+		properties := make(map[string]PLYProperty)
+		for j, property := range element.properties {
+			if property.countType == "" {
+				properties[property.name] = PLYProperty{property.valueType, columns[j]}
+			}
+		}
+		return properties, nil
+	}
+	return nil, fmt.Errorf("PLY file has no %s element", name)
+}
+
+func getPLYValueReader(reader *bufio.Reader, format string) (plyValueReader, error) {
+	switch format {
+	case "ascii":
+		return &plyTextReader{reader: reader}, nil
+	case "binary_little_endian":
+		return &plyBinaryReader{reader: reader, order: binary.LittleEndian}, nil
+	case "binary_big_endian":
+		return &plyBinaryReader{reader: reader, order: binary.BigEndian}, nil
+	}
+	return nil, fmt.Errorf("unsupported PLY format %q", format)
+}
+
+func readPLYHeader(reader *bufio.Reader) (string, []plyElement, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ply" {
+		return "", nil, errors.New("not a PLY file")
+	}
+	format := ""
+	var elements []plyElement
+	for {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return "", nil, errors.New("PLY header has no end")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) < 3 || fields[2] != "1.0" {
+				return "", nil, fmt.Errorf("unsupported PLY format %q", strings.TrimSpace(line))
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) < 3 {
+				return "", nil, fmt.Errorf("invalid PLY element %q", strings.TrimSpace(line))
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 || count > maxPLYElements {
+				return "", nil, fmt.Errorf("invalid count of PLY element %q", strings.TrimSpace(line))
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, errors.New("PLY property doesn't belong to any element")
+			}
+			var property plyProperty
+			if len(fields) == 5 && fields[1] == "list" {
+				property = plyProperty{fields[4], fields[3], fields[2]}
+			} else if len(fields) == 3 {
+				property = plyProperty{fields[2], fields[1], ""}
+			} else {
+				return "", nil, fmt.Errorf("invalid PLY property %q", strings.TrimSpace(line))
+			}
+			if plyTypeSizes[property.valueType] == 0 || (property.countType != "" && plyTypeSizes[property.countType] == 0) {
+				return "", nil, fmt.Errorf("unsupported type of PLY property %q", strings.TrimSpace(line))
+			}
+			element := &elements[len(elements) - 1]
+			element.properties = append(element.properties, property)
+		case "end_header":
+			if format == "" {
+				return "", nil, errors.New("PLY header has no format")
+			}
+			return format, elements, nil
+		}
+		// Comments and obj_info lines are skipped.
+	}
+}
+
+func readPLYVertices(values plyValueReader, element plyElement, builder *meshBuilder) error {
+	axes := map[string]int{"x": 0, "y": 1, "z": 2}
+	normalAxes := map[string]int{"nx": 0, "ny": 1, "nz": 2}
+	found, foundNormals := 0, 0
+	for _, property := range element.properties {
+		if property.countType != "" {
+			continue
+		}
+		if _, ok := axes[property.name]; ok {
+			found++
+		} else if _, ok := normalAxes[property.name]; ok {
+			foundNormals++
+		}
+	}
+	if found < 3 {
+		return errors.New("PLY vertices need x, y and z properties")
+	}
+	hasNormals := foundNormals == 3
+
+	// Vertices are appended as they're read, count from the header can't be trusted
+	// to allocate all of them upfront.
+	builder.positions, builder.normals = builder.positions[:0], builder.normals[:0]
+	for i := 0; i < element.count; i++ {
+		builder.positions = append(builder.positions, mgl32.Vec3{})
+		if hasNormals {
+			builder.normals = append(builder.normals, mgl32.Vec3{})
+		}
+		for _, property := range element.properties {
+			if property.countType != "" {
+				if err := skipPLYList(values, property); err != nil {
+					return fmt.Errorf("vertex %d: %v", i + 1, err)
+				}
+				continue
+			}
+			value, err := values.read(property.valueType)
+			if err != nil {
+				return fmt.Errorf("vertex %d: %v", i + 1, err)
+			}
+			if axis, ok := axes[property.name]; ok {
+				builder.positions[i][axis] = float32(value)
+			} else if axis, ok := normalAxes[property.name]; ok && hasNormals {
+				builder.normals[i][axis] = float32(value)
+			}
+		}
+	}
+	return nil
+}
+
+// readPLYFace reads a face and appends indices of its vertices to indices.
+func readPLYFace(values plyValueReader, element plyElement, indices []int) ([]int, error) {
+	found := false
+	for _, property := range element.properties {
+		if property.countType == "" || found || (property.name != "vertex_indices" && property.name != "vertex_index") {
+			var err error
+			if property.countType == "" {
+				_, err = values.read(property.valueType)
+			} else {
+				err = skipPLYList(values, property)
+			}
+			if err != nil {
+				return indices, err
+			}
+			continue
+		}
+		found = true
+		count, err := readPLYListCount(values, property)
+		if err != nil {
+			return indices, err
+		}
+		for i := 0; i < count; i++ {
+			index, err := values.read(property.valueType)
+			if err != nil {
+				return indices, err
+			}
+			indices = append(indices, int(index))
+		}
+	}
+	if !found {
+		return indices, errors.New("PLY faces need vertex_indices property")
+	}
+	return indices, nil
+}
+
+func skipPLYElement(values plyValueReader, element plyElement) error {
+	for i := 0; i < element.count; i++ {
+		for _, property := range element.properties {
+			var err error
+			if property.countType == "" {
+				_, err = values.read(property.valueType)
+			} else {
+				err = skipPLYList(values, property)
+			}
+			if err != nil {
+				return fmt.Errorf("%s %d: %v", element.name, i + 1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func skipPLYList(values plyValueReader, property plyProperty) error {
+	count, err := readPLYListCount(values, property)
+	for i := 0; i < count && err == nil; i++ {
+		_, err = values.read(property.valueType)
+	}
+	return err
+}
+
+func readPLYListCount(values plyValueReader, property plyProperty) (int, error) {
+	count, err := values.read(property.countType)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 || count > maxPLYElements {
+		return 0, fmt.Errorf("invalid length of list %s", property.name)
+	}
+	return int(count), nil
+}
+
+// plyTextReader reads values of ASCII PLY files, separated by whitespace.
+type plyTextReader struct {
+	reader *bufio.Reader
+	token  []byte
+}
+
+func (values *plyTextReader) read(valueType string) (float64, error) {
+	values.token = values.token[:0]
+	for {
+		character, err := values.reader.ReadByte()
+		if err == io.EOF && len(values.token) > 0 {
+			break
+		}
+		if err != nil {
+			return 0, errors.New("unexpected end of PLY file")
+		}
+		if character == ' ' || character == '\t' || character == '\n' || character == '\r' {
+			if len(values.token) > 0 {
+				break
+			}
+			continue
+		}
+		values.token = append(values.token, character)
+	}
+	value, err := strconv.ParseFloat(string(values.token), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", values.token)
+	}
+	return value, nil
+}
+
+// plyBinaryReader reads values of binary PLY files.
+type plyBinaryReader struct {
+	reader *bufio.Reader
+	order  binary.ByteOrder
+	buffer [8]byte
+}
+
+func (values *plyBinaryReader) read(valueType string) (float64, error) {
+	data := values.buffer[:plyTypeSizes[valueType]]
+	if _, err := io.ReadFull(values.reader, data); err != nil {
+		return 0, errors.New("unexpected end of PLY file")
+	}
+	switch valueType {
+	case "char", "int8":
+		return float64(int8(data[0])), nil
+	case "uchar", "uint8":
+		return float64(data[0]), nil
+	case "short", "int16":
+		return float64(int16(values.order.Uint16(data))), nil
+	case "ushort", "uint16":
+		return float64(values.order.Uint16(data)), nil
+	case "int", "int32":
+		return float64(int32(values.order.Uint32(data))), nil
+	case "uint", "uint32":
+		return float64(values.order.Uint32(data)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(values.order.Uint32(data))), nil
+	}
+	return math.Float64frombits(values.order.Uint64(data)), nil
+}
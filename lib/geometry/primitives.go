@@ -0,0 +1,234 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// All primitives fit into the box [-0.5, 0.5]^3. Elongated primitives (capsule, cylinder, prism, torus)
+// are built around the z axis.
+
+// Number of segments used for curved parts of primitives.
+const (
+	roundSegments = 24
+	arcSegments   = 6
+	bevelSegments = 3
+)
+
+// Maximum bevel radius of box, at which the box becomes a sphere.
+const MaxBevel = 0.5
+
+// GetBox returns box with edges rounded by bevel radius. Box with zero bevel has 24 vertices,
+// 4 per face.
+func GetBox(bevel float64) MeshData {
+	bevel = math.Max(0.0, math.Min(bevel, MaxBevel))
+	segments := 0
+	if bevel > 0.0 {
+		segments = bevelSegments
+	}
+
+	// Face coordinates go over flat part of the face and then over rounded edges on both sides.
+	// Tangents of angles are used, so normalized offsets below are spread evenly by angle.
+	inner := 0.5 - bevel
+	coordinates := make([]float64, 0, 2 * segments + 2)
+	for i := segments; i > 0; i-- {
+		coordinates = append(coordinates, -inner - bevel * math.Tan(float64(i) / float64(segments) * math.Pi / 4.0))
+	}
+	coordinates = append(coordinates, -inner, inner)
+	for i := 1; i <= segments; i++ {
+		coordinates = append(coordinates, inner + bevel * math.Tan(float64(i) / float64(segments) * math.Pi / 4.0))
+	}
+
+	var mesh MeshData
+	for axis := 0; axis < 3; axis++ {
+		for _, side := range []float64{-1.0, 1.0} {
+			first := uint32(mesh.VertexCount())
+			for _, u := range coordinates {
+				for _, v := range coordinates {
+					var point [3]float64
+					point[axis] = side * 0.5
+					point[(axis + 1) % 3] = u
+					point[(axis + 2) % 3] = v
+					position, normal := getRoundedBoxPoint(point, inner, bevel, axis, side)
+					mesh.addVertex(position, normal)
+				}
+			}
+			mesh.addGrid(first, len(coordinates), len(coordinates))
+		}
+	}
+	return mesh
+}
+
+// getRoundedBoxPoint projects point on face of a box onto box with rounded edges. Rounded box
+// is a set of points within bevel distance from inner box [-inner, inner]^3.
+func getRoundedBoxPoint(point [3]float64, inner, bevel float64, axis int, side float64) (mgl32.Vec3, mgl32.Vec3) {
+	var closest, offset [3]float64
+	for i := range point {
+		closest[i] = math.Max(-inner, math.Min(point[i], inner))
+		offset[i] = point[i] - closest[i]
+	}
+	length := math.Sqrt(offset[0] * offset[0] + offset[1] * offset[1] + offset[2] * offset[2])
+	var normal [3]float64
+	if length > 1e-9 {
+		for i := range normal {
+			normal[i] = offset[i] / length
+		}
+	} else {
+		normal[axis] = side
+	}
+	var position mgl32.Vec3
+	for i := range position {
+		position[i] = float32(closest[i] + normal[i] * bevel)
+	}
+	return position, mgl32.Vec3{float32(normal[0]), float32(normal[1]), float32(normal[2])}
+}
+
+// GetSphere returns sphere with diameter 1.
+func GetSphere() MeshData {
+	var mesh MeshData
+	mesh.addLathe(getEllipseArc(0.0, 0.0, 0.5, 0.5, -math.Pi / 2.0, math.Pi / 2.0, 2 * arcSegments), roundSegments, false)
+	return mesh
+}
+
+// GetCapsule returns cylinder with hemispherical ends. The ends are squashed along z axis,
+// so the capsule fills unit box.
+func GetCapsule() MeshData {
+	profile := getEllipseArc(0.0, -0.25, 0.5, 0.25, -math.Pi / 2.0, 0.0, arcSegments)
+	profile = append(profile, getEllipseArc(0.0, 0.25, 0.5, 0.25, 0.0, math.Pi / 2.0, arcSegments)...)
+	var mesh MeshData
+	mesh.addLathe(profile, roundSegments, false)
+	return mesh
+}
+
+// GetCylinder returns cylinder with diameter 1 and height 1.
+func GetCylinder() MeshData {
+	var mesh MeshData
+	mesh.addLathe(getCylinderProfile(), roundSegments, false)
+	return mesh
+}
+
+// GetPrism returns prism with regular polygon base with the number of sides. Vertices of the base
+// lie on a circle with diameter 1.
+func GetPrism(sides int) MeshData {
+	if sides < 3 {
+		sides = 3
+	}
+	var mesh MeshData
+	mesh.addLathe(getCylinderProfile(), sides, true)
+	return mesh
+}
+
+// GetTorus returns torus with outer diameter 1. Thickness is the ratio of tube diameter to outer diameter.
+func GetTorus(thickness float64) MeshData {
+	thickness = math.Max(0.01, math.Min(thickness, 0.5))
+	tubeRadius := thickness * 0.5
+	var mesh MeshData
+	mesh.addLathe(getEllipseArc(0.5 - tubeRadius, 0.0, tubeRadius, tubeRadius, -math.Pi, math.Pi, 2 * arcSegments), roundSegments, false)
+	return mesh
+}
+
+// profilePoint is a point of a profile revolved around z axis. Normal lies in the plane
+// spanned by the z axis and the radial direction.
+type profilePoint struct {
+	radius, z             float64
+	normalRadial, normalZ float64
+}
+
+// getEllipseArc returns profile of elliptic arc centered at (radius, z) going from angle start
+// to angle end. Angles are measured from the radial direction towards the z axis.
+func getEllipseArc(radius, z, radiusScale, zScale, start, end float64, segments int) []profilePoint {
+	profile := make([]profilePoint, segments + 1)
+	for i := range profile {
+		angle := start + (end - start) * float64(i) / float64(segments)
+		cos, sin := math.Cos(angle), math.Sin(angle)
+		normalRadial, normalZ := cos / radiusScale, sin / zScale
+		length := math.Hypot(normalRadial, normalZ)
+		pointRadius := radius + cos * radiusScale
+		// Points on the axis have to be exactly on it, so they're connected by triangles.
+		if pointRadius < 1e-9 {
+			pointRadius = 0.0
+		}
+		profile[i] = profilePoint{
+			pointRadius, z + sin * zScale,
+			normalRadial / length, normalZ / length,
+		}
+	}
+	return profile
+}
+
+// getCylinderProfile returns profile of a cylinder with sharp edges. Points on edges are repeated
+// with different normals.
+func getCylinderProfile() []profilePoint {
+	return []profilePoint{
+		{0.0, -0.5, 0.0, -1.0},
+		{0.5, -0.5, 0.0, -1.0},
+		{0.5, -0.5, 1.0, 0.0},
+		{0.5, 0.5, 1.0, 0.0},
+		{0.5, 0.5, 0.0, 1.0},
+		{0.0, 0.5, 0.0, 1.0},
+	}
+}
+
+// addLathe adds surface created by revolving profile around z axis in segments. Consecutive points
+// of profile are connected, unless they are at the same position. Flat lathe has faceted sides
+// with normals perpendicular to the faces.
+func (mesh *MeshData) addLathe(profile []profilePoint, segments int, flat bool) {
+	getVertex := func(point profilePoint, angle, normalAngle float64) (mgl32.Vec3, mgl32.Vec3) {
+		position := mgl32.Vec3{
+			float32(point.radius * math.Cos(angle)),
+			float32(point.radius * math.Sin(angle)),
+			float32(point.z),
+		}
+		normal := mgl32.Vec3{
+			float32(point.normalRadial * math.Cos(normalAngle)),
+			float32(point.normalRadial * math.Sin(normalAngle)),
+			float32(point.normalZ),
+		}
+		return position, normal.Normalize()
+	}
+	connect := func(first uint32, columns int) {
+		for i := 0; i < len(profile) - 1; i++ {
+			if profile[i].radius == profile[i + 1].radius && profile[i].z == profile[i + 1].z {
+				continue
+			}
+			for column := 0; column < columns - 1; column++ {
+				a := first + uint32(i * columns + column)
+				b := a + uint32(columns)
+				// Quads touching the axis are triangles.
+				if profile[i].radius == 0.0 {
+					mesh.addTriangle(a, b, b + 1)
+				} else if profile[i + 1].radius == 0.0 {
+					mesh.addTriangle(a, a + 1, b)
+				} else {
+					mesh.addQuad(a, a + 1, b + 1, b)
+				}
+			}
+		}
+	}
+
+	step := 2.0 * math.Pi / float64(segments)
+	if flat {
+		// Every face has its own vertices, so normals aren't shared between faces.
+		for segment := 0; segment < segments; segment++ {
+			start, end := float64(segment) * step, float64(segment + 1) * step
+			first := uint32(mesh.VertexCount())
+			for _, point := range profile {
+				mesh.addVertex(getVertex(point, start, (start + end) / 2.0))
+				mesh.addVertex(getVertex(point, end, (start + end) / 2.0))
+			}
+			connect(first, 2)
+		}
+		return
+	}
+
+	// Seam vertices are repeated, so the grid doesn't need to wrap around.
+	first := uint32(mesh.VertexCount())
+	for _, point := range profile {
+		for segment := 0; segment <= segments; segment++ {
+			angle := float64(segment) * step
+			mesh.addVertex(getVertex(point, angle, angle))
+		}
+	}
+	connect(first, segments + 1)
+}
@@ -0,0 +1,71 @@
+package geometry
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestPrimitives(t *testing.T) {
+	const epsilon = 1e-5
+	primitives := []struct {
+		name string
+		mesh MeshData
+		// Radius of circle in the xy plane the surface is built around, triangles face away from it.
+		// Convex primitives are built around the origin.
+		ringRadius float32
+	}{
+		{"box", GetBox(0.0), 0.0},
+		{"beveled box", GetBox(0.1), 0.0},
+		{"box with maximum bevel", GetBox(MaxBevel), 0.0},
+		{"sphere", GetSphere(), 0.0},
+		{"capsule", GetCapsule(), 0.0},
+		{"cylinder", GetCylinder(), 0.0},
+		{"triangular prism", GetPrism(3), 0.0},
+		{"hexagonal prism", GetPrism(6), 0.0},
+		{"torus", GetTorus(0.25), 0.375},
+	}
+	for _, primitive := range primitives {
+		t.Run(primitive.name, func(t *testing.T) {
+			mesh := &primitive.mesh
+			if len(mesh.Indices) == 0 || len(mesh.Indices) % 3 != 0 {
+				t.Fatalf("mesh has %d indices", len(mesh.Indices))
+			}
+			for i := 0; i < mesh.VertexCount(); i++ {
+				position, normal := mesh.Position(uint32(i)), mesh.Normal(uint32(i))
+				for _, coordinate := range position {
+					if coordinate < -0.5 - epsilon || coordinate > 0.5 + epsilon {
+						t.Fatalf("vertex %d at %v is out of bounds", i, position)
+					}
+				}
+				if length := normal.Len(); length < 1.0 - epsilon || length > 1.0 + epsilon {
+					t.Fatalf("normal %v of vertex %d has length %f", normal, i, length)
+				}
+			}
+
+			// Triangles are wound clockwise when seen from outside, degenerate ones have no winding.
+			wound := 0
+			for i := 0; i < len(mesh.Indices); i += 3 {
+				a, b, c := mesh.Position(mesh.Indices[i]), mesh.Position(mesh.Indices[i + 1]), mesh.Position(mesh.Indices[i + 2])
+				faceNormal := b.Sub(a).Cross(c.Sub(a))
+				if faceNormal.Len() < 1e-7 {
+					continue
+				}
+				centroid := a.Add(b).Add(c).Mul(1.0 / 3.0)
+				var center mgl32.Vec3
+				radial := mgl32.Vec2{centroid[0], centroid[1]}
+				if primitive.ringRadius > 0.0 && radial.Len() > 0.0 {
+					radial = radial.Normalize().Mul(primitive.ringRadius)
+					center = mgl32.Vec3{radial[0], radial[1], 0.0}
+				}
+				if faceNormal.Dot(centroid.Sub(center)) >= 0.0 {
+					t.Fatalf("triangle %d (%v, %v, %v) isn't wound clockwise from outside", i / 3, a, b, c)
+				}
+				wound++
+			}
+			if wound == 0 {
+				t.Error("mesh has only degenerate triangles")
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package graphics
 
 import (
+	"reflect"
+
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
@@ -10,6 +12,8 @@ import (
 type InstanceBuffer struct {
 	buffer uint32
 	numElements int32
+	// Number of items buffer's storage can hold and number of items it holds.
+	capacity, count int
 }
 
 // Byte size of a single element in InstanceBuffer.
@@ -20,14 +24,40 @@ const elementByteSize = 4
 func GetInstanceBuffer(numElements int32) InstanceBuffer {
 	var buffer uint32
 	gl.GenBuffers(1, &buffer)
-	return InstanceBuffer{buffer, numElements}
+	return InstanceBuffer{buffer: buffer, numElements: numElements}
 }
 
-// UpdateInstanceBuffer updates data inside InstanceBuffer.
-func UpdateInstanceBuffer(buffer InstanceBuffer, count int, data interface{}) {
-	totalBufferSize := count * elementByteSize * int(buffer.numElements)
+// UpdateInstanceBuffer updates data inside InstanceBuffer. Buffer's storage grows if data doesn't fit into it.
+// count is clamped to the number of items in data, so data is never read out of bounds.
+func UpdateInstanceBuffer(buffer *InstanceBuffer, count int, data interface{}) {
+	itemByteSize := elementByteSize * int(buffer.numElements)
+	if dataByteSize, ok := getSliceByteSize(data); ok && count * itemByteSize > dataByteSize {
+		count = dataByteSize / itemByteSize
+	}
+	if count < 0 {
+		count = 0
+	}
+	buffer.count = count
+	if count == 0 {
+		return
+	}
+
 	gl.BindBuffer(gl.ARRAY_BUFFER, buffer.buffer)
-	gl.BufferData(gl.ARRAY_BUFFER, totalBufferSize, gl.Ptr(data), gl.DYNAMIC_DRAW)
+	if count > buffer.capacity {
+		// Leave some space, so slowly growing data doesn't reallocate the storage every time.
+		buffer.capacity = count + count / 2
+		gl.BufferData(gl.ARRAY_BUFFER, buffer.capacity * itemByteSize, nil, gl.DYNAMIC_DRAW)
+	}
+	gl.BufferSubData(gl.ARRAY_BUFFER, 0, count * itemByteSize, gl.Ptr(data))
+}
+
+// getSliceByteSize returns size of slice's data in bytes, if data is a slice.
+func getSliceByteSize(data interface{}) (int, bool) {
+	value := reflect.ValueOf(data)
+	if value.Kind() != reflect.Slice {
+		return 0, false
+	}
+	return value.Len() * int(value.Type().Elem().Size()), true
 }
 
 // SetInstanceBuffer sets elements in InstanceBuffer as vertex attributes for instanced rendering.
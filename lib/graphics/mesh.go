@@ -57,7 +57,16 @@ func DrawMesh(mesh Mesh) {
 
 // DrawMeshInstanced sends a command to draw instanceCount instances of Mesh to current framebuffer.
 // It also binds InstanceBuffers specified in instanceBuffers slice to locations specified by instanceBufferLocations.
+// Instance count is clamped to the number of items in instance buffers.
 func DrawMeshInstanced(mesh Mesh, instanceCount int32, instanceBuffers []InstanceBuffer, instanceBufferLocations []uint32) {
+	for i := range instanceBuffers {
+		if int32(instanceBuffers[i].count) < instanceCount {
+			instanceCount = int32(instanceBuffers[i].count)
+		}
+	}
+	if instanceCount <= 0 {
+		return
+	}
 	gl.BindVertexArray(mesh.Vao)
 	for i := range instanceBuffers {
 		SetInstanceBuffer(instanceBuffers[i], instanceBufferLocations[i])
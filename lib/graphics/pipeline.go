@@ -71,6 +71,9 @@ func (pipeline *Pipeline) SetUniform(uniform string, value interface{}) {
 	case float32:
 		number := value.(float32)
 		SetUniformFloat(uniformLocation, number)
+	case int32:
+		number := value.(int32)
+		SetUniformInt(uniformLocation, number)
 	case mgl32.Vec2:
 		vector := value.(mgl32.Vec2)
 		SetUniformVec2(uniformLocation, vector)
@@ -83,6 +86,9 @@ func (pipeline *Pipeline) SetUniform(uniform string, value interface{}) {
 	case mgl32.Vec4:
 		vector := value.(mgl32.Vec4)
 		SetUniformVec4(uniformLocation, vector)
+	case []mgl32.Vec4:
+		vectorSlice := value.([]mgl32.Vec4)
+		SetUniformVec4A(uniformLocation, vectorSlice)
 	case mgl32.Mat4:
 		matrix := value.(mgl32.Mat4)
 		SetUniformMatrix(uniformLocation, matrix)
@@ -116,6 +116,11 @@ func SetUniformFloat(uniform Uniform, v float32) {
 	gl.Uniform1f(int32(uniform), v)
 }
 
+// SetUniformInt sets uniform int value.
+func SetUniformInt(uniform Uniform, v int32) {
+	gl.Uniform1i(int32(uniform), v)
+}
+
 // SetUniformVec3 sets uniform Vec3 value.
 func SetUniformVec3(uniform Uniform, v mgl32.Vec3) {
 	gl.Uniform3fv(int32(uniform), 1, &v[0])
@@ -135,3 +140,8 @@ func SetUniformVec2(uniform Uniform, v mgl32.Vec2) {
 func SetUniformVec4(uniform Uniform, v mgl32.Vec4) {
 	gl.Uniform4fv(int32(uniform), 1, &v[0])
 }
+
+// SetUniformVec4A sets uniform array of Vec4 values.
+func SetUniformVec4A(uniform Uniform, v []mgl32.Vec4) {
+	gl.Uniform4fv(int32(uniform), int32(len(v)), &v[0][0])
+}
@@ -23,9 +23,16 @@ func charCallback(w *glfw.Window, r rune) {
 	inputString += string(r)
 }
 
+var droppedFiles []string
+var currentDroppedFiles []string
+func dropCallback(w *glfw.Window, names []string) {
+	droppedFiles = append(droppedFiles, names...)
+}
+
 func initInput(window *glfw.Window) {
 	window.SetScrollCallback(scrollCallback)
 	window.SetCharCallback(charCallback)
+	window.SetDropCallback(dropCallback)
 
 	keyDown = make(map[Key]bool)
 	keyPressed = make(map[Key]bool)
@@ -44,6 +51,10 @@ func Update(window *glfw.Window) {
 	currentInputString = inputString
 	inputString = ""
 
+	// Update files dropped onto the window.
+	currentDroppedFiles = droppedFiles
+	droppedFiles = nil
+
 	// Update mouse position and get position delta.
 	x, y := window.GetCursorPos()
 	wx, wy := window.GetSize()
@@ -94,6 +105,21 @@ func GetCharsPressed() string {
 	return result
 }
 
+// GetDroppedFiles returns paths of files dropped onto the window since the last update.
+func GetDroppedFiles() []string {
+	return currentDroppedFiles
+}
+
+// GetClipboardString returns text currently in the system clipboard.
+func GetClipboardString(window *glfw.Window) (string, error) {
+	return window.GetClipboardString()
+}
+
+// SetClipboardString puts text into the system clipboard.
+func SetClipboardString(window *glfw.Window, text string) {
+	window.SetClipboardString(text)
+}
+
 func GetMouseDeltaPosition() (float64, float64) {
 	return dmouseX, dmouseY
 }
@@ -203,6 +203,59 @@ func (panel *Panel) AddToggle(label string, active bool) (newValue bool, changed
 	return newValue, changed
 }
 
+// AddButton adds a button with label and reports whether it was pressed.
+func (panel *Panel) AddButton(label string) (pressed bool) {
+    stringID := panel.name + "/button/" + label
+    buttonID := hashString(stringID)
+
+    height := float32(uiFont.GetStringHeight())
+    buttonPos := panel.position.Add(panel.itemPos)
+    buttonSize := mgl32.Vec2{200.0, height}
+
+    // Check for mouse input
+    if isInputResponsive {
+        mouseX, mouseY := platform.GetMousePosition()
+        if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, buttonPos, buttonSize) {
+            setHot(buttonID)
+        } else {
+            unsetHot(buttonID)
+        }
+
+        // Button is pressed on mouse press, it stays active until the mouse is released.
+        if isHot(buttonID) && platform.IsMouseLeftButtonPressed() {
+            pressed = true
+            setActive(buttonID)
+        } else if isActive(buttonID) && !platform.IsMouseLeftButtonDown() {
+            unsetActive(buttonID)
+        }
+    } else {
+        unsetHot(buttonID)
+    }
+
+    textColor := colorLabel
+    if isHot(buttonID) {
+        textColor = colorHover
+        borderSize := float32(2.0)
+        rectRenderingBuffer = append(rectRenderingBuffer, rectRenderingData {
+            buttonPos.Sub(mgl32.Vec2{borderSize, borderSize}), buttonSize.Add(mgl32.Vec2{borderSize * 2, borderSize * 2}), colorHover, 2,
+        })
+    }
+    rectRenderingBuffer = append(rectRenderingBuffer, rectRenderingData {
+        buttonPos, buttonSize, colorForeground, 3,
+    })
+
+    // Button label, centered in the button
+    textWidth := float32(uiFont.GetStringWidth(label))
+    textPos := mgl32.Vec2{buttonPos[0] + (buttonSize[0] - textWidth) / 2.0, buttonPos[1]}
+    textRenderingBuffer = append(textRenderingBuffer, textRenderingData {
+        label, textPos, mgl32.Vec2{}, textColor, &uiFont,
+    })
+
+    panel.itemPos[1] += height + innerPadding
+    panel.maxWidth = math.Max(panel.maxWidth, float64(buttonPos[0] + buttonSize[0]))
+    return pressed
+}
+
 func (panel *Panel) AddTextField(label string, text string) (newValue string, changed bool) {
     changed = false
     newValue = text
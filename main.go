@@ -1,11 +1,15 @@
 package main
 
 import (
+	"flag"
 	"image"
 	_ "image/png"
 	"io/ioutil"
+	"log"
 	"math"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"runtime"
@@ -14,6 +18,7 @@ import (
 
 	"./app"
 	"./lib/font"
+	"./lib/geometry"
 	"./lib/graphics"
 	"./lib/platform"
 	"./lib/ui"
@@ -26,9 +31,13 @@ var   uiColorHover 	   = mgl32.Vec4{0.0, 0.0, 0.0, 0.8}
 var   uiColorInactive  = mgl32.Vec4{0.0, 0.0, 0.0, 0.01}
 var   textColor		   = mgl32.Vec4{0.0, 0.0, 0.0, 0.6}
 
-// Screenshot constants
-const screenshotTextDuration 	 = 1.75
-const screenshotTextFadeDuration = 1.0
+// Notice constants
+const screenshotNoticeDuration = 1.75
+const errorNoticeDuration	   = 5.0
+const noticeFadeDuration 	   = 1.0
+
+// Maximum number of cells in a layer, it can be changed by a flag.
+var maxCellsCount = 250000
 
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -102,16 +111,125 @@ func radiusMaxCellToCtrl(radius float64) float64 {
 	return radius + 4.0
 }
 
+// Count slider is logarithmic, so both small and huge counts can be set with it.
+func countToSliderPortion(count float64) float64 {
+	if count <= 1.0 || maxCellsCount <= 1 {
+		return 0.0
+	}
+	return math.Min(math.Log(count) / math.Log(float64(maxCellsCount)), 1.0)
+}
+
+func sliderPortionToCount(portion float64) float64 {
+	return math.Round(math.Pow(float64(maxCellsCount), portion))
+}
+
 // APP RENDER
-func drawCells(cells []app.Cell, cellsSettings app.CellSettings, mesh graphics.Mesh) {
-	matrices := app.GetCellModelMatrices(cells, cellsSettings.RadiusMin, cellsSettings.RadiusMax, cellsSettings.PolarStd,
-		cellsSettings.PolarMean, cellsSettings.HeightRatio, cellsSettings.Count)
-	colors := app.GetCellColors(cells, cellsSettings.Colors, cellsSettings.Count)
-	app.DrawMeshInstanced(mesh, matrices, colors, cellsSettings.Count)
+func drawCells(cells []app.Cell, cellsSettings app.CellSettings, colors []mgl32.Vec4) {
+	matrices := app.GetCellModelMatrices(cells, cellsSettings)
+	app.DrawMeshInstanced(app.GetCellShapeMesh(&cellsSettings.Shape), matrices, colors, cellsSettings.Count, cellsSettings.Material)
+}
+
+// renderSettings renders scene with specified settings and returns its pixels along with dimensions.
+// Cells are generated from the layers' seeds, so the scene looks exactly as it did when it was saved.
+// Cells slice grows when it's too small for any of the layers.
+func renderSettings(settings app.AppSettings, cells *[]app.Cell,
+	targetBuffer graphics.Framebuffer, sceneView app.SceneView, projectionMatrix mgl32.Mat4) ([]byte, int32, int32) {
+	for _, layer := range settings.Layers {
+		layer.Count = app.GetLayerCount(&layer, maxCellsCount)
+		if len(*cells) < layer.Count {
+			*cells = make([]app.Cell, layer.Count)
+		}
+		layerCells := (*cells)[:layer.Count]
+		app.GenerateCells(layerCells, layer.Seed)
+		drawCells(layerCells, layer, app.GetLayerColors(layerCells, &layer))
+	}
+	camera := app.GetCamera(settings.Camera.Radius, settings.Camera.Azimuth, settings.Camera.Polar, settings.Camera.Height)
+	viewMatrix := camera.GetViewMatrix()
+
+	app.RenderScene(targetBuffer, sceneView, viewMatrix, projectionMatrix, &settings.Rendering)
+	app.ResetScene()
+
+	return app.GetSceneBuffer(sceneView)
+}
+
+// getSettingsThumbnail renders scene with specified settings and returns it as a texture for settings bar.
+func getSettingsThumbnail(settings app.AppSettings, cells *[]app.Cell,
+	targetBuffer graphics.Framebuffer, sceneView app.SceneView, projectionMatrix mgl32.Mat4) graphics.Texture {
+	imageBytes, imageWidth, imageHeight := renderSettings(settings, cells, targetBuffer, sceneView, projectionMatrix)
+	return graphics.GetTextureUint8(int(imageWidth), int(imageHeight), 4, []uint8(imageBytes), true)
+}
+
+// sortSettings sorts presets and settings bar by the bar's sort key. It returns
+// new index of preset which was at selectedIndex.
+func sortSettings(settingsBar *app.SettingsBar, selectedIndex int) int {
+	permutation := app.SortSettings(settingsBar.SortKey)
+	settingsBar.ReorderSettings(permutation)
+	for i, index := range permutation {
+		if index == selectedIndex {
+			return i
+		}
+	}
+	return -1
+}
+
+// getIndexAfterRemoval returns new index of preset at index after preset at removedIndex
+// was removed, or -1 if it was the removed one.
+func getIndexAfterRemoval(index, removedIndex int) int {
+	if index == removedIndex {
+		return -1
+	} else if index > removedIndex {
+		return index - 1
+	}
+	return index
+}
+
+// getSettingsStore returns presets store at path. Paths ending with ".zip" are
+// treated as single-file archives, anything else as a directory.
+func getSettingsStore(path string) app.SettingsStore {
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		return app.GetArchiveSettingsStore(path)
+	}
+	return app.GetDirectorySettingsStore(path)
 }
 
 func main() {
-	settings, settingsCount := app.LoadSettings()
+	presetsPath := flag.String("presets", app.SAVES_DIR, "directory or .zip archive with saved presets")
+	gpuTransforms := flag.Bool("gpu-transforms", true, "compute transforms of cells on GPU, if their distribution allows it")
+	flag.IntVar(&maxCellsCount, "max-cells", maxCellsCount, "maximum number of cells in a layer")
+	exportFormat := flag.String("export-scene", "", "export cells of active settings as "+app.SceneFormatGLTF+" or "+app.SceneFormatOBJ+" scene and exit")
+	pointsFormat := flag.String("export-points", "", "export cells of active settings as "+app.PointsFormatCSV+", "+app.PointsFormatJSON+" or "+app.PointsFormatPLY+" point cloud and exit")
+	animationTime := flag.Float64("animation-time", 0.0, "starting time of animation clock in seconds, cells are exported as they are at this time")
+	flag.Parse()
+	if maxCellsCount < 1 {
+		maxCellsCount = 1
+	}
+	app.SetAnimationTime(*animationTime)
+
+	// Problems with loading settings are not fatal, we'll just let user know about them.
+	noticeText, noticeTimer := "", 0.0
+	settings, settingsCount, err := app.LoadSettings(getSettingsStore(*presetsPath))
+	if err != nil {
+		log.Println(err)
+		noticeText, noticeTimer = err.Error(), errorNoticeDuration
+	}
+
+	// Scenes can be exported without opening the window.
+	if *exportFormat != "" {
+		path, err := app.ExportScene(settings, *exportFormat, maxCellsCount)
+		if err != nil {
+			log.Fatalln("couldn't export scene:", err)
+		}
+		log.Println("scene exported to", path)
+		return
+	}
+	if *pointsFormat != "" {
+		path, err := app.ExportCellPoints(settings, *pointsFormat, maxCellsCount)
+		if err != nil {
+			log.Fatalln("couldn't export cells:", err)
+		}
+		log.Println("cells exported to", path)
+		return
+	}
 	
 	var windowWidth = 1600
 	var windowHeight = 900
@@ -154,25 +272,27 @@ func main() {
 	}
 	screenBuffer := graphics.GetFramebufferDefault()
 
-	// Load cell mesh.
-	cube := graphics.GetMesh(cubeVertices[:], cubeIndices[:], []int{4, 4})
-
-	// Create cells array.
-	cells := make([]app.Cell, 10000)
-	app.GenerateCells(cells)
+	// Create cell layers.
+	layers := make([]app.CellLayer, 0, app.MaxLayers)
+	for _, layerSettings := range settings.Layers {
+		layers = append(layers, app.GetCellLayer(layerSettings, maxCellsCount))
+	}
+	// Layer edited by UI, circle controllers and count slider.
+	activeLayer := 0
 
-	// Create channel used to update asynchronously cell colors.
-	colorChannel := make(chan []mgl32.Vec4, 1)
+	// Cells used when rendering thumbnails of other presets.
+	thumbnailCells := make([]app.Cell, 0)
 
-	// Colors parameters
-	colorsParams := make([]app.ColorParameter, 0)
-	for _, color := range settings.Cells.Colors {
-		colorsParams = append(colorsParams, app.ColorParameter{color, color})
+	// Create channel used to update asynchronously cell colors, of the layer which was active when they were requested.
+	type layerPalette struct {
+		layer  int
+		colors []mgl32.Vec4
 	}
+	colorChannel := make(chan layerPalette, 1)
 
 	// Circle controllers
-	innerCircleController := app.GetCircleController(radiusMinCellToCtrl(settings.Cells.RadiusMin), 0)
-	outerCircleController := app.GetCircleController(radiusMaxCellToCtrl(settings.Cells.RadiusMax), 0)
+	innerCircleController := app.GetCircleController(radiusMinCellToCtrl(settings.Layers[activeLayer].RadiusMin), 0)
+	outerCircleController := app.GetCircleController(radiusMaxCellToCtrl(settings.Layers[activeLayer].RadiusMax), 0)
 
 	// COUNTS
 	// TODO: move to specific file
@@ -181,8 +301,18 @@ func main() {
 
 	// Count controller parameters
 	countSliderColor := app.ColorParameter{uiColor, uiColor}
-	countSliderValue := app.FloatParameter{float64(settings.Cells.Count), float64(settings.Cells.Count)}
+	countSliderValue := app.FloatParameter{float64(settings.Layers[activeLayer].Count), float64(settings.Layers[activeLayer].Count)}
 	countSliderHot, countSliderActive := false, false
+	// Count as entered in UI, it's only updated when count's target changes, so it can be edited.
+	countText, countTextValue := "", -1
+	meshText, meshTextValue := "", ""
+	pointsText, pointsTextValue := "", ""
+
+	// Cell under mouse and cell picked for editing, as layer and cell indices. They're -1 when there's none.
+	hoveredLayer, hoveredCell := -1, -1
+	pickedLayer, pickedCell := -1, -1
+	// Animation clock stops while it's paused, so cells can be looked at or exported at the same time.
+	animationPaused := false
 	
 	// Help parameters
 	helpOffsetRight := float32(100.0)
@@ -194,11 +324,14 @@ func main() {
 
 	// Runtime variables
 	showUI := false
-	pickerStates := make([]bool, len(settings.Cells.Colors))
+
+	// Currently selected preset and its metadata being edited.
+	selectedPreset := -1
+	var presetMetadata app.PresetMetadata
+	presetTags := ""
 
 	start := time.Now()
 	timeSinceMouseMovement := 0.0
-	screenshotTextTimer := 0.0
 
 	aspectRatio := float64(windowWidth)/float64(windowHeight)
 	projectionMatrix := mgl32.Perspective(mgl32.DegToRad(60.0), float32(aspectRatio), near, far)
@@ -206,22 +339,81 @@ func main() {
 
 	// UI - depends on RENDERING
 	for i := 0; i < settingsCount; i++ {
-		settings := app.GetSettings(i)
-		drawCells(cells, settings.Cells, cube)
-		camera := app.GetCamera(settings.Camera.Radius, settings.Camera.Azimuth, settings.Camera.Polar, settings.Camera.Height)
-		viewMatrix := camera.GetViewMatrix()
-
-		app.RenderScene(screenBuffer, sceneView, viewMatrix, projectionMatrix, &settings.Rendering)
-		app.ResetScene()
-
-		imageBytes, imageWidth, imageHeight := app.GetSceneBuffer(sceneView)
-		texture := graphics.GetTextureUint8(int(imageWidth), int(imageHeight), 4, []uint8(imageBytes), true)
-		settingsBar.AddSettings(texture)
+		texture := getSettingsThumbnail(app.GetSettings(i), &thumbnailCells, screenBuffer, sceneView, projectionMatrix)
+		settingsBar.AddSettings(texture, app.GetSettingsMetadata(i))
 	}
+	settingsChanges, stopWatchingSettings := app.WatchSettings()
+	defer stopWatchingSettings()
 
 	// RENDERING
 	camera := app.GetCamera(settings.Camera.Radius, settings.Camera.Azimuth, settings.Camera.Polar, settings.Camera.Height)
 
+	// selectLayer makes layer at index the active one, controls are set to its state.
+	selectLayer := func(index int) {
+		activeLayer = index
+		layer := &layers[activeLayer]
+		innerCircleController.Radius = app.FloatParameter{radiusMinCellToCtrl(layer.RadiusMin.Val), radiusMinCellToCtrl(layer.RadiusMin.Target)}
+		outerCircleController.Radius = app.FloatParameter{radiusMaxCellToCtrl(layer.RadiusMax.Val), radiusMaxCellToCtrl(layer.RadiusMax.Target)}
+		countSliderValue = layer.Count
+	}
+
+	// syncActiveLayer copies state of the controls into the active layer and its settings.
+	syncActiveLayer := func() {
+		layer := &layers[activeLayer]
+		layer.RadiusMin = app.FloatParameter{radiusMinCtrlToCell(innerCircleController.Radius.Val), radiusMinCtrlToCell(innerCircleController.Radius.Target)}
+		layer.RadiusMax = app.FloatParameter{radiusMaxCtrlToCell(outerCircleController.Radius.Val), radiusMaxCtrlToCell(outerCircleController.Radius.Target)}
+		layer.Count = countSliderValue
+		settings.Layers[activeLayer].RadiusMin = layer.RadiusMin.Val
+		settings.Layers[activeLayer].RadiusMax = layer.RadiusMax.Val
+		settings.Layers[activeLayer].Count = layer.GetCount()
+	}
+
+	// applySettings replaces current settings, controls transition smoothly to the new values.
+	// Layers which are not in the current settings appear right away.
+	applySettings := func(newSettings app.AppSettings) {
+		syncActiveLayer()
+		currentLayers := settings.Layers
+		settings = newSettings
+		settings.Layers = currentLayers
+		for i, layerSettings := range newSettings.Layers {
+			if i >= len(layers) {
+				layers = append(layers, app.GetCellLayer(layerSettings, maxCellsCount))
+				settings.Layers = append(settings.Layers, app.CopyCellSettings(layerSettings))
+				continue
+			}
+			layers[i].SetTarget(&settings.Layers[i], layerSettings)
+		}
+		for _, layerSettings := range newSettings.Layers {
+			if layerSettings.Shape.Mesh != "" && app.LoadCellMesh(layerSettings.Shape.Mesh) != nil {
+				noticeText, noticeTimer = "MESH LOADING FAILED", errorNoticeDuration
+			}
+			if layerSettings.Points.File != "" && app.LoadCellPoints(layerSettings.Points.File) != nil {
+				noticeText, noticeTimer = "POINTS LOADING FAILED", errorNoticeDuration
+			}
+		}
+		layers = layers[:len(newSettings.Layers)]
+		settings.Layers = settings.Layers[:len(newSettings.Layers)]
+		if activeLayer >= len(layers) {
+			activeLayer = len(layers) - 1
+		}
+		selectLayer(activeLayer)
+
+		camera.SetStateWithTransition(settings.Camera.Radius, settings.Camera.Azimuth,
+			settings.Camera.Polar, settings.Camera.Height)
+	}
+
+	// getTargetSettings returns settings which the controls are transitioning to.
+	// These are the settings recorded in history.
+	getTargetSettings := func() app.AppSettings {
+		target := settings
+		target.Layers = make([]app.CellSettings, len(layers))
+		for i := range layers {
+			target.Layers[i] = layers[i].GetTarget(settings.Layers[i])
+		}
+		return target
+	}
+	history := app.GetHistory(getTargetSettings())
+
 	// Start our fancy-shmancy loop
 	for !window.ShouldClose() {
 		now := time.Now()
@@ -229,28 +421,192 @@ func main() {
 		start = now
 		platform.Update(window)
 
+		// PRESETS
+		// Presets could have been changed by another running instance.
+		select {
+		case <-settingsChanges:
+			removed, changed, added, err := app.SyncSettings()
+			if err != nil {
+				log.Println("couldn't sync settings:", err)
+				break
+			}
+			for _, index := range removed {
+				settingsBar.RemoveSettings(index)
+				selectedPreset = getIndexAfterRemoval(selectedPreset, index)
+			}
+			for _, index := range changed {
+				texture := getSettingsThumbnail(app.GetSettings(index), &thumbnailCells, screenBuffer, sceneView, projectionMatrix)
+				settingsBar.SetSettingsTexture(index, texture)
+				settingsBar.SetSettingsMetadata(index, app.GetSettingsMetadata(index))
+			}
+			settingsCount = len(settingsBar.SettingsTextures)
+			for i := settingsCount; i < settingsCount+added; i++ {
+				texture := getSettingsThumbnail(app.GetSettings(i), &thumbnailCells, screenBuffer, sceneView, projectionMatrix)
+				settingsBar.AddSettings(texture, app.GetSettingsMetadata(i))
+			}
+			settingsCount += added
+			selectedPreset = sortSettings(&settingsBar, selectedPreset)
+		default:
+		}
+
+		// Export selected preset (or current settings if there's none selected) as a bundle.
+		if platform.IsKeyPressed(platform.KeyE) && !ui.IsRegisteringInput {
+			exportSettings := settings
+			imageBytes, imageWidth, imageHeight := app.GetSceneBuffer(sceneView)
+			if selectedPreset >= 0 {
+				exportSettings = app.GetSettings(selectedPreset)
+				imageBytes, imageWidth, imageHeight = renderSettings(exportSettings, &thumbnailCells, screenBuffer, sceneView, projectionMatrix)
+			}
+			thumbnail := app.GetThumbnailImage(imageBytes, int(imageWidth), int(imageHeight))
+			path, err := app.ExportSettingsBundle(exportSettings, thumbnail)
+			if err != nil {
+				log.Println("couldn't export preset:", err)
+				noticeText, noticeTimer = "EXPORT FAILED", errorNoticeDuration
+			} else {
+				noticeText, noticeTimer = "EXPORTED TO "+path, screenshotNoticeDuration
+			}
+		}
+
+		// Export cells as glTF scene, or OBJ scene with shift.
+		if platform.IsKeyPressed(platform.KeyG) && !ui.IsRegisteringInput {
+			format := app.SceneFormatGLTF
+			if platform.IsKeyDown(platform.KeyLeftShift) || platform.IsKeyDown(platform.KeyRightShift) {
+				format = app.SceneFormatOBJ
+			}
+			path, err := app.ExportScene(settings, format, maxCellsCount)
+			if err != nil {
+				log.Println("couldn't export scene:", err)
+				noticeText, noticeTimer = "EXPORT FAILED", errorNoticeDuration
+			} else {
+				noticeText, noticeTimer = "EXPORTED TO "+path, screenshotNoticeDuration
+			}
+		}
+
+		// Export cells as CSV point cloud, JSON with shift or PLY with control.
+		if platform.IsKeyPressed(platform.KeyP) && !ui.IsRegisteringInput {
+			format := app.PointsFormatCSV
+			if platform.IsKeyDown(platform.KeyLeftShift) || platform.IsKeyDown(platform.KeyRightShift) {
+				format = app.PointsFormatJSON
+			} else if platform.IsKeyDown(platform.KeyLeftControl) || platform.IsKeyDown(platform.KeyRightControl) {
+				format = app.PointsFormatPLY
+			}
+			path, err := app.ExportCellPoints(settings, format, maxCellsCount)
+			if err != nil {
+				log.Println("couldn't export cells:", err)
+				noticeText, noticeTimer = "EXPORT FAILED", errorNoticeDuration
+			} else {
+				noticeText, noticeTimer = "EXPORTED TO "+path, screenshotNoticeDuration
+			}
+		}
+
+		// HISTORY
+		isControlDown := platform.IsKeyDown(platform.KeyLeftControl) || platform.IsKeyDown(platform.KeyRightControl)
+		if isControlDown && platform.IsKeyPressed(platform.KeyZ) && !ui.IsRegisteringInput {
+			var change app.HistoryChange
+			if platform.IsKeyDown(platform.KeyLeftShift) || platform.IsKeyDown(platform.KeyRightShift) {
+				change, err = history.Redo(getTargetSettings())
+			} else {
+				change, err = history.Undo(getTargetSettings())
+			}
+			if err != nil {
+				log.Println("couldn't undo/redo:", err)
+				noticeText, noticeTimer = "UNDO FAILED", errorNoticeDuration
+			}
+			if change.Settings != nil {
+				if !change.RestoreCamera {
+					change.Settings.Camera = settings.Camera
+				}
+				applySettings(*change.Settings)
+				history.Sync(getTargetSettings())
+				selectedPreset = -1
+			}
+			if change.PresetRestored {
+				settingsCount = len(settingsBar.SettingsTextures) + 1
+				texture := getSettingsThumbnail(app.GetSettings(settingsCount - 1), &thumbnailCells, screenBuffer, sceneView, projectionMatrix)
+				settingsBar.AddSettings(texture, app.GetSettingsMetadata(settingsCount - 1))
+				selectedPreset = sortSettings(&settingsBar, selectedPreset)
+			}
+			if change.PresetRemoved >= 0 {
+				settingsBar.RemoveSettings(change.PresetRemoved)
+				settingsCount = len(settingsBar.SettingsTextures)
+				selectedPreset = getIndexAfterRemoval(selectedPreset, change.PresetRemoved)
+			}
+		}
+
+		// Share codes - copy current settings into clipboard or load settings from it.
+		if platform.IsKeyPressed(platform.KeyF5) {
+			platform.SetClipboardString(window, app.EncodeShareCode(settings))
+			noticeText, noticeTimer = "SHARE CODE COPIED", screenshotNoticeDuration
+		}
+		if platform.IsKeyPressed(platform.KeyF6) {
+			code, err := platform.GetClipboardString(window)
+			if err == nil {
+				var sharedSettings app.AppSettings
+				sharedSettings, err = app.DecodeShareCode(code)
+				if err == nil {
+					applySettings(sharedSettings)
+					history.Record(getTargetSettings(), true)
+					selectedPreset = -1
+				}
+			}
+			if err != nil {
+				log.Println("couldn't load share code:", err)
+				noticeText, noticeTimer = "INVALID SHARE CODE", errorNoticeDuration
+			} else {
+				noticeText, noticeTimer = "SHARE CODE LOADED", screenshotNoticeDuration
+			}
+		}
+
+		// Import bundles dropped onto the window.
+		for _, path := range platform.GetDroppedFiles() {
+			if !strings.HasSuffix(strings.ToLower(path), app.BundleExtension) {
+				continue
+			}
+			count, thumbnail, err := app.ImportSettingsBundle(path)
+			if err != nil {
+				log.Println("couldn't import preset:", err)
+				noticeText, noticeTimer = "IMPORT FAILED", errorNoticeDuration
+				continue
+			}
+			settingsCount = count
+			bounds := thumbnail.Bounds()
+			texture := graphics.GetTextureUint8(bounds.Dx(), bounds.Dy(), 4, thumbnail.Pix, true)
+			settingsBar.AddSettings(texture, app.GetSettingsMetadata(settingsCount - 1))
+			selectedPreset = sortSettings(&settingsBar, selectedPreset)
+			noticeText, noticeTimer = "PRESET IMPORTED", screenshotNoticeDuration
+		}
+
 		// CELLS
-		if platform.IsKeyPressed(platform.KeyR) {
-			app.GenerateCells(cells)
+		// Letter shortcuts are ignored while typing into UI.
+		// Shortcuts change the active layer only.
+		if platform.IsKeyPressed(platform.KeyR) && !ui.IsRegisteringInput {
+			layers[activeLayer].SetSeed(&settings.Layers[activeLayer], app.GetRandomSeed())
 		}
-		if platform.IsKeyPressed(platform.KeyC) {
+		if platform.IsKeyPressed(platform.KeyC) && !ui.IsRegisteringInput {
+			layer := activeLayer
+			paletteSize := len(layers[layer].ColorsParams)
 			go func() {
-				newColors := app.GetRandomColorPalette()
-				if newColors != nil {
-					colorChannel <- newColors
-				}
+				colorChannel <- layerPalette{layer, app.GetRandomColorPalette(paletteSize)}
 			}()
 		}
 		select {
-		case newColors := <-colorChannel:
-			for i := range colorsParams {
-				colorsParams[i].Target = newColors[i]
+		case palette := <-colorChannel:
+			// The layer could have been removed in the meantime.
+			if palette.layer < len(layers) {
+				layers[palette.layer].SetPalette(&settings.Layers[palette.layer], palette.colors)
 			}
 		default:
 		}
-		for i := range settings.Cells.Colors {
-			colorsParams[i].Update(dt, 5.0)
-			settings.Cells.Colors[i] = colorsParams[i].Val
+		// Animated cells are placed at time of the clock, it stops while animation is paused.
+		if platform.IsKeyPressed(platform.KeySpace) && !ui.IsRegisteringInput {
+			animationPaused = !animationPaused
+		}
+		if !animationPaused {
+			app.SetAnimationTime(app.GetAnimationTime() + dt)
+		}
+		// Shape of the active layer is then overridden by the controls.
+		for i := range layers {
+			layers[i].Update(dt, &settings.Layers[i])
 		}
 		// UI
 		if platform.IsKeyPressed(platform.KeyEscape) {
@@ -294,15 +650,70 @@ func main() {
 				isMouseOverAdvancedSettings = true
 			}
 
-			// Colors/material related settings.
+			// Layer selection. Panels below edit the active layer.
 			nextWidth := panel.GetWidth()
+			panel = ui.StartPanel("Layers", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
+			if panel.AddButton("Layer " + strconv.Itoa(activeLayer + 1) + " of " + strconv.Itoa(len(layers))) {
+				syncActiveLayer()
+				selectLayer((activeLayer + 1) % len(layers))
+			}
+			// New layer is a copy of the active one with different cells, so it can be tweaked from there.
+			if len(layers) < app.MaxLayers && panel.AddButton("Add layer") {
+				syncActiveLayer()
+				layerSettings := layers[activeLayer].GetTarget(settings.Layers[activeLayer])
+				layerSettings.Seed = app.GetRandomSeed()
+				layers = append(layers, app.GetCellLayer(layerSettings, maxCellsCount))
+				settings.Layers = append(settings.Layers, layerSettings)
+				selectLayer(len(layers) - 1)
+			}
+			if len(layers) > 1 && panel.AddButton("Remove layer") {
+				layers = append(layers[:activeLayer], layers[activeLayer + 1:]...)
+				settings.Layers = append(settings.Layers[:activeLayer], settings.Layers[activeLayer + 1:]...)
+				if activeLayer >= len(layers) {
+					activeLayer = len(layers) - 1
+				}
+				selectLayer(activeLayer)
+			}
+			panel.End()
+
+			panelRect = panel.GetBoundingRect()
+			if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
+				isMouseOverAdvancedSettings = true
+			}
+
+			// Colors/material related settings.
+			layer, layerSettings := &layers[activeLayer], &settings.Layers[activeLayer]
 			panel = ui.StartPanel("Material", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
-			settings.Rendering.Roughness, _ = panel.AddSlider("Roughness", settings.Rendering.Roughness, 0, 1.0)
-			settings.Rendering.Reflectivity, _ = panel.AddSlider("Reflectivity", settings.Rendering.Reflectivity, 0, 1.0)
-			for i := range settings.Cells.Colors {
-				pickerStates[i], _ = panel.AddColorPalette("Color"+strconv.Itoa(i), settings.Cells.Colors[i], pickerStates[i])
-				if pickerStates[i] {
-					colorsParams[i].Target, _ = panel.AddColorPicker("Pick"+strconv.Itoa(i), colorsParams[i].Target, false)
+			layerSettings.Material.Roughness, _ = panel.AddSlider("Roughness", layerSettings.Material.Roughness, 0, 1.0)
+			layerSettings.Material.Reflectivity, _ = panel.AddSlider("Reflectivity", layerSettings.Material.Reflectivity, 0, 1.0)
+			for i := range layerSettings.Colors {
+				layer.PickerStates[i], _ = panel.AddColorPalette("Color"+strconv.Itoa(i), layerSettings.Colors[i], layer.PickerStates[i])
+				if layer.PickerStates[i] {
+					layer.ColorsParams[i].Target, _ = panel.AddColorPicker("Pick"+strconv.Itoa(i), layer.ColorsParams[i].Target, false)
+				}
+			}
+			// New color is a copy of the last one, so it can be tweaked from there.
+			if len(layer.ColorsParams) < app.MaxPaletteSize && panel.AddButton("Add color") {
+				palette := layer.GetTarget(*layerSettings).Colors
+				layer.SetPalette(layerSettings, append(palette, palette[len(palette) - 1]))
+			}
+			if len(layer.ColorsParams) > app.MinPaletteSize && panel.AddButton("Remove color") {
+				palette := layer.GetTarget(*layerSettings).Colors
+				layer.SetPalette(layerSettings, palette[:len(palette) - 1])
+			}
+			// How colors of the palette are assigned to cells and parameters of the coloring.
+			coloring := app.GetCellColoring(layerSettings.Coloring.Name)
+			if panel.AddButton("Colors: " + strings.ToUpper(coloring.Name())) {
+				layerSettings.Coloring.Name = app.GetNextCellColoring(coloring.Name())
+			}
+			coloringParameters := app.GetColoringParameters(&layerSettings.Coloring, len(layerSettings.Colors))
+			for _, parameter := range coloring.Parameters(len(layerSettings.Colors)) {
+				value, changed := panel.AddSlider(parameter.Name, coloringParameters[parameter.Name], parameter.Min, parameter.Max)
+				if changed {
+					if layerSettings.Coloring.Parameters == nil {
+						layerSettings.Coloring.Parameters = make(map[string]float64)
+					}
+					layerSettings.Coloring.Parameters[parameter.Name] = value
 				}
 			}
 			panel.End()
@@ -311,16 +722,262 @@ func main() {
 			if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
 				isMouseOverAdvancedSettings = true
 			}
+
+			// Distribution of cells and its parameters.
+			panel = ui.StartPanel("Cells", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
+			if int(countSliderValue.Target) != countTextValue {
+				countTextValue = int(countSliderValue.Target)
+				countText = strconv.Itoa(countTextValue)
+			}
+			countChanged := false
+			countText, countChanged = panel.AddTextField("Count", countText)
+			if countChanged {
+				count, err := strconv.Atoi(strings.TrimSpace(countText))
+				if err == nil {
+					countSliderValue.Target = math.Max(1, math.Min(float64(count), float64(maxCellsCount)))
+				}
+				// Show the count which was actually set.
+				countTextValue = -1
+			}
+			distribution := app.GetCellDistribution(layerSettings.Distribution.Name)
+			if panel.AddButton("Shape: " + strings.ToUpper(distribution.Name())) {
+				layerSettings.Distribution.Name = app.GetNextCellDistribution(distribution.Name())
+			}
+			if distribution.Name() == app.DefaultDistributionName {
+				layerSettings.PolarStd, _ = panel.AddSlider("PolarStd", layerSettings.PolarStd, 0, 1.0)
+				layerSettings.PolarMean, _ = panel.AddSlider("PolarMean", layerSettings.PolarMean, 0, math.Pi)
+			}
+			parameters := app.GetDistributionParameters(&layerSettings.Distribution)
+			for _, parameter := range distribution.Parameters() {
+				value, changed := panel.AddSlider(parameter.Name, parameters[parameter.Name], parameter.Min, parameter.Max)
+				if changed {
+					if layerSettings.Distribution.Parameters == nil {
+						layerSettings.Distribution.Parameters = make(map[string]float64)
+					}
+					layerSettings.Distribution.Parameters[parameter.Name] = value
+				}
+			}
+			// Dimensions of cells, they're computed from the cells' normalized ones, so cells stay in place.
+			if panel.AddButton("Size: " + strings.ToUpper(layerSettings.Scale.Distribution)) {
+				layerSettings.Scale.Distribution = app.GetNextScaleDistribution(layerSettings.Scale.Distribution)
+			}
+			layerSettings.Scale.WidthMin, _ = panel.AddSlider("WidthMin", layerSettings.Scale.WidthMin, 0.01, 10.0)
+			layerSettings.Scale.WidthMax, _ = panel.AddSlider("WidthMax", layerSettings.Scale.WidthMax, 0.01, 10.0)
+			uniformLabel := "Uniform: OFF"
+			if layerSettings.Scale.Uniform {
+				uniformLabel = "Uniform: ON"
+			}
+			if panel.AddButton(uniformLabel) {
+				layerSettings.Scale.Uniform = !layerSettings.Scale.Uniform
+			}
+			if !layerSettings.Scale.Uniform {
+				layerSettings.Scale.DepthMin, _ = panel.AddSlider("DepthMin", layerSettings.Scale.DepthMin, 0.01, 10.0)
+				layerSettings.Scale.DepthMax, _ = panel.AddSlider("DepthMax", layerSettings.Scale.DepthMax, 0.01, 10.0)
+			}
+			layerSettings.HeightRatio, _ = panel.AddSlider("HeightRatio", layerSettings.HeightRatio, 0.1, 5.0)
+			// Overlapping cells are shrunk or hidden, so their faces don't flicker.
+			overlapLabel := "Overlaps: ALLOWED"
+			if layerSettings.Overlap.Avoid {
+				overlapLabel = "Overlaps: AVOIDED"
+			}
+			if panel.AddButton(overlapLabel) {
+				layerSettings.Overlap.Avoid = !layerSettings.Overlap.Avoid
+			}
+			if layerSettings.Overlap.Avoid {
+				layerSettings.Overlap.MinGap, _ = panel.AddSlider("MinGap", layerSettings.Overlap.MinGap, 0.0, 1.0)
+			}
+			// Copies of cells count towards the cell count, so the scene doesn't get heavier with symmetry.
+			fold, _ := panel.AddSlider("Fold", float64(layerSettings.Symmetry.Fold), 1.0, app.MaxSymmetryFold)
+			layerSettings.Symmetry.Fold = int(math.Round(fold))
+			mirrors := []struct {
+				name   string
+				mirror *bool
+			}{
+				{"MirrorX", &layerSettings.Symmetry.MirrorX},
+				{"MirrorY", &layerSettings.Symmetry.MirrorY},
+				{"MirrorZ", &layerSettings.Symmetry.MirrorZ},
+			}
+			for _, mirror := range mirrors {
+				mirrorLabel := mirror.name + ": OFF"
+				if *mirror.mirror {
+					mirrorLabel = mirror.name + ": ON"
+				}
+				if panel.AddButton(mirrorLabel) {
+					*mirror.mirror = !*mirror.mirror
+				}
+			}
+			// Mesh every cell of the layer is drawn with. Mesh file is used instead of the shape, when it's set.
+			if layerSettings.Shape.Mesh != "" {
+				if panel.AddButton("Mesh: " + strings.ToUpper(filepath.Base(layerSettings.Shape.Mesh))) {
+					layerSettings.Shape.Mesh = ""
+				}
+			} else {
+				if panel.AddButton("Mesh: " + strings.ToUpper(layerSettings.Shape.Name)) {
+					layerSettings.Shape.Name = app.GetNextCellShape(layerSettings.Shape.Name)
+				}
+				if app.CellShapeHasBevel(layerSettings.Shape.Name) {
+					layerSettings.Shape.Bevel, _ = panel.AddSlider("Bevel", layerSettings.Shape.Bevel, 0.0, geometry.MaxBevel)
+				}
+			}
+			if layerSettings.Shape.Mesh != meshTextValue {
+				meshTextValue = layerSettings.Shape.Mesh
+				meshText = meshTextValue
+			}
+			meshChanged := false
+			meshText, meshChanged = panel.AddTextField("MeshFile", meshText)
+			if meshChanged {
+				path := strings.TrimSpace(meshText)
+				if path == "" {
+					layerSettings.Shape.Mesh = ""
+				} else if err := app.LoadCellMesh(path); err != nil {
+					noticeText, noticeTimer = "MESH LOADING FAILED", errorNoticeDuration
+				} else {
+					layerSettings.Shape.Mesh = path
+				}
+				// Show the mesh which was actually set.
+				meshText = layerSettings.Shape.Mesh
+			}
+			// Point cloud placing cells instead of their distribution. Files with several layers replace all layers.
+			if layerSettings.Points.File != pointsTextValue {
+				pointsTextValue = layerSettings.Points.File
+				pointsText = pointsTextValue
+			}
+			pointsChanged := false
+			pointsText, pointsChanged = panel.AddTextField("PointsFile", pointsText)
+			if pointsChanged {
+				path := strings.TrimSpace(pointsText)
+				if path == "" {
+					layerSettings.Points = app.PointsSettings{}
+				} else if newSettings, err := app.ImportCellPoints(getTargetSettings(), activeLayer, path, maxCellsCount); err != nil {
+					noticeText, noticeTimer = "POINTS LOADING FAILED", errorNoticeDuration
+				} else {
+					applySettings(newSettings)
+				}
+				// Show the file which was actually set.
+				pointsText = settings.Layers[activeLayer].Points.File
+			}
+			panel.End()
+
+			panelRect = panel.GetBoundingRect()
+			if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
+				isMouseOverAdvancedSettings = true
+			}
+
+			// Motions of cells, amplitude of a motion is shown only when it's on. Settings could have been
+			// replaced above, so they're fetched again.
+			layerSettings = &settings.Layers[activeLayer]
+			panel = ui.StartPanel("Animation", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
+			motions := []struct {
+				name             string
+				motion           *app.AnimationMotion
+				maxSpeed, maxAmplitude float64
+			}{
+				{"Orbit", &layerSettings.Animation.Orbit, 1.0, 1.0},
+				{"Breathe", &layerSettings.Animation.Breathe, 2.0, 1.0},
+				{"Wave", &layerSettings.Animation.Wave, 2.0, 5.0},
+				{"Spin", &layerSettings.Animation.Spin, 2.0, 1.0},
+			}
+			for _, motion := range motions {
+				motion.motion.Speed, _ = panel.AddSlider(motion.name + "Speed", motion.motion.Speed, -motion.maxSpeed, motion.maxSpeed)
+				if motion.motion.Speed != 0 {
+					motion.motion.Amplitude, _ = panel.AddSlider(motion.name + "Amplitude", motion.motion.Amplitude, 0.0, motion.maxAmplitude)
+				}
+			}
+			panel.End()
+
+			panelRect = panel.GetBoundingRect()
+			if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
+				isMouseOverAdvancedSettings = true
+			}
+
+			// Overrides of the cell picked in the scene. Settings could have been replaced above, so they're fetched again.
+			layer, layerSettings = &layers[activeLayer], &settings.Layers[activeLayer]
+			if pickedLayer == activeLayer && pickedCell >= 0 && pickedCell < layerSettings.Count {
+				panel = ui.StartPanel("Cell", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
+				override := app.GetCellOverride(layerSettings, pickedCell)
+				overrideChanged, changed := false, false
+				hiddenLabel := "Hidden: OFF"
+				if override.Hidden {
+					hiddenLabel = "Hidden: ON"
+				}
+				if panel.AddButton(hiddenLabel) {
+					override.Hidden = !override.Hidden
+					overrideChanged = true
+				}
+				override.Scale, changed = panel.AddSlider("CellScale", override.Scale, 0.1, 5.0)
+				overrideChanged = overrideChanged || changed
+				colorLabel := "Color: PALETTE"
+				if override.HasColor {
+					colorLabel = "Color: OWN"
+				}
+				if panel.AddButton(colorLabel) {
+					// Own color starts as the color the cell has.
+					if !override.HasColor {
+						override.Color = layer.GetCellColor(layerSettings, pickedCell)
+					}
+					override.HasColor = !override.HasColor
+					overrideChanged = true
+				}
+				if override.HasColor {
+					override.Color, changed = panel.AddColorPicker("CellColor", override.Color, false)
+					overrideChanged = overrideChanged || changed
+				}
+				if panel.AddButton("Reset cell") {
+					override = app.CellOverride{Index: pickedCell, Seed: layerSettings.Seed, Scale: 1.0}
+					overrideChanged = true
+				}
+				if overrideChanged {
+					app.SetCellOverride(layerSettings, override)
+				}
+				panel.End()
+
+				panelRect = panel.GetBoundingRect()
+				if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
+					isMouseOverAdvancedSettings = true
+				}
+			}
+
+			// Metadata of the selected preset. Changes are saved when editing of a field is finished.
+			if selectedPreset >= 0 {
+				panel = ui.StartPanel("Preset", mgl32.Vec2{panelX, panel.GetBottom()}, float64(nextWidth))
+				metadataChanged := false
+				nameChanged, tagsChanged := false, false
+				presetMetadata.Name, nameChanged = panel.AddTextField("Name", presetMetadata.Name)
+				presetTags, tagsChanged = panel.AddTextField("Tags", presetTags)
+				rating, _ := panel.AddSlider("Rating", float64(presetMetadata.Rating), 0, app.MaxRating)
+				if int(math.Round(rating)) != presetMetadata.Rating {
+					presetMetadata.Rating = int(math.Round(rating))
+					metadataChanged = true
+				}
+				panel.End()
+
+				if nameChanged || tagsChanged || metadataChanged {
+					presetMetadata.Tags = app.ParseTags(presetTags)
+					err := app.UpdateSettingsMetadata(selectedPreset, presetMetadata)
+					if err != nil {
+						log.Println("couldn't update preset:", err)
+						noticeText, noticeTimer = "UPDATE FAILED", errorNoticeDuration
+					} else {
+						presetMetadata = app.GetSettingsMetadata(selectedPreset)
+						settingsBar.SetSettingsMetadata(selectedPreset, presetMetadata)
+					}
+				}
+
+				panelRect = panel.GetBoundingRect()
+				if isInRect(mgl32.Vec2{float32(mouseX), float32(mouseY)}, mgl32.Vec2{panelRect[0], panelRect[1]}, mgl32.Vec2{panelRect[2], panelRect[3]}) {
+					isMouseOverAdvancedSettings = true
+				}
+			}
 		}
 
-		// Show screenshot text.
-		screenshotTextPart := math.Min(screenshotTextTimer/screenshotTextFadeDuration, 1.0)
-		alpha := math.Sqrt(screenshotTextPart)
+		// Show notice text.
+		noticeTextPart := math.Min(noticeTimer/noticeFadeDuration, 1.0)
+		alpha := math.Sqrt(noticeTextPart)
 		if alpha > 0.0 {
-			app.DrawUIText("IMAGE SAVED", &infoFont, mgl32.Vec2{float32(windowWidth) / 2.0, float32(windowHeight) - 10}, mgl32.Vec4{0.0, 0.0, 0.0, float32(alpha) * 0.8}, mgl32.Vec2{0.5, 1.0}, 0)
+			app.DrawUIText(noticeText, &infoFont, mgl32.Vec2{float32(windowWidth) / 2.0, float32(windowHeight) - 10}, mgl32.Vec4{0.0, 0.0, 0.0, float32(alpha) * 0.8}, mgl32.Vec2{0.5, 1.0}, 0)
 		}
-		if screenshotTextTimer > 0.0 {
-			screenshotTextTimer -= dt
+		if noticeTimer > 0.0 {
+			noticeTimer -= dt
 		}
 
 		// UI
@@ -348,24 +1005,33 @@ func main() {
 		action, index := settingsBar.Update(dt, float32(mouseX), float32(mouseY), hideUI)
 		switch action {
 		case app.SAVE:
+			settingsCount, err = app.SaveSettings(settings)
+			if err != nil {
+				log.Println("couldn't save settings:", err)
+				noticeText, noticeTimer = "SAVE FAILED", errorNoticeDuration
+				break
+			}
 			imageBytes, imageWidth, imageHeight := app.GetSceneBuffer(sceneView)
 			texture := graphics.GetTextureUint8(int(imageWidth), int(imageHeight), 4, []uint8(imageBytes), true)
-			settingsBar.AddSettings(texture)
-
-			settingsCount = app.SaveSettings(settings)
+			settingsBar.AddSettings(texture, app.GetSettingsMetadata(settingsCount - 1))
+			selectedPreset = sortSettings(&settingsBar, selectedPreset)
+		case app.SORT:
+			selectedPreset = sortSettings(&settingsBar, selectedPreset)
 		case app.SELECT:
-			settings = app.GetSettings(index)
-			camera.SetStateWithTransition(settings.Camera.Radius, settings.Camera.Azimuth,
-				settings.Camera.Polar, settings.Camera.Height)
-			countSliderValue.Target = float64(settings.Cells.Count)
-			outerCircleController.Radius.Target = radiusMaxCellToCtrl(settings.Cells.RadiusMax)
-			innerCircleController.Radius.Target = radiusMinCellToCtrl(settings.Cells.RadiusMin)
-			for i := range colorsParams {
-				colorsParams[i].Target = settings.Cells.Colors[i]
-			}
+			applySettings(app.GetSettings(index))
+			history.Record(getTargetSettings(), true)
+			selectedPreset = index
+			presetMetadata = app.GetSettingsMetadata(index)
+			presetTags = app.FormatTags(presetMetadata.Tags)
 		case app.DELETE:
-			settingsCount = app.DeleteSettings(index)
+			settingsCount, err = history.DeleteSettings(index)
+			if err != nil {
+				log.Println("couldn't delete settings:", err)
+				noticeText, noticeTimer = "DELETE FAILED", errorNoticeDuration
+				break
+			}
 			settingsBar.RemoveSettings(index)
+			selectedPreset = getIndexAfterRemoval(selectedPreset, index)
 		}
 
 		viewMatrix := camera.GetViewMatrix()
@@ -395,6 +1061,43 @@ func main() {
 			app.DrawMeshSceneUI(circleOuter, mgl32.Ident4(), outerCircleController.Color.Val)
 		}
 		
+		// PICKING
+		// With advanced settings shown, cell under mouse is highlighted and clicking it picks it for editing,
+		// clicking elsewhere drops it. Cells aren't picked while mouse is used by other controls.
+		canPick := showUI && !ui.IsRegisteringInput && action == app.NONE && settingsBar.Hidden && !isMouseOverAdvancedSettings &&
+			!innerCircleController.IsHot() && !outerCircleController.IsHot() && !countSliderHot && !countSliderActive &&
+			!platform.IsKeyDown(platform.KeyLeftAlt)
+		if !canPick {
+			hoveredLayer, hoveredCell = -1, -1
+		} else if timeSinceMouseMovement == 0.0 || platform.IsMouseLeftButtonPressed() {
+			hoveredLayer, hoveredCell = -1, -1
+			nearest := float32(math.Inf(1))
+			for i := range layers {
+				cell, distance := layers[i].Pick(&settings.Layers[i], rS.Vec3(), rD.Vec3())
+				if cell >= 0 && distance < nearest {
+					hoveredLayer, hoveredCell, nearest = i, cell, distance
+				}
+			}
+		}
+		if canPick && platform.IsMouseLeftButtonPressed() {
+			pickedLayer, pickedCell = hoveredLayer, hoveredCell
+			if pickedLayer >= 0 && pickedLayer != activeLayer {
+				syncActiveLayer()
+				selectLayer(pickedLayer)
+			}
+		}
+		for i := range layers {
+			if hideUI || !showUI {
+				layers[i].SetHighlight(-1)
+			} else if i == hoveredLayer {
+				layers[i].SetHighlight(hoveredCell)
+			} else if i == pickedLayer {
+				layers[i].SetHighlight(pickedCell)
+			} else {
+				layers[i].SetHighlight(-1)
+			}
+		}
+
 		// TODO: Should be encapsulated.
 		// COUNT
 		{
@@ -413,18 +1116,13 @@ func main() {
 					portion = 1.0
 				}
 				portion = float32(math.Max(0.0, math.Min(float64(portion), 1.0)))
-				countSliderValue.Target = float64(portion) * 10000.0
-			}
-			settings.Cells.Count = int(countSliderValue.Val)
-			if settings.Cells.Count > 10000 {
-				settings.Cells.Count = 10000
-			} else if settings.Cells.Count <= 0 {
-				settings.Cells.Count = 1
+				countSliderValue.Target = sliderPortionToCount(float64(portion))
 			}
 			countSliderColor.Update(dt, 5.0)
 			countSliderValue.Update(dt, 15.0)
+			syncActiveLayer()
 
-			portion := float32(countSliderValue.Val) / 10000.0
+			portion := float32(countToSliderPortion(countSliderValue.Val))
 			countSliderSize := mgl32.Vec2{countSliderBgSize[0], countSliderBgSize[1] * portion}
 			countSliderPos := mgl32.Vec2{countSliderBgPos[0], countSliderBgPos[1] + countSliderBgSize[1] - countSliderSize[1]}
 
@@ -447,20 +1145,38 @@ func main() {
 		app.DrawUIText("randomize cells", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
 		app.DrawUIText("- R", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
 		helpY += float32(infoFont.RowHeight)
+		app.DrawUIText("edit cell", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
+		app.DrawUIText("- click", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
+		helpY += float32(infoFont.RowHeight)
+		app.DrawUIText("pause animation", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
+		app.DrawUIText("- Space", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
+		helpY += float32(infoFont.RowHeight)
 		app.DrawUIText("advanced settings", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
 		app.DrawUIText("- F2", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
 		helpY += float32(infoFont.RowHeight)
 		app.DrawUIText("screenshot", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
 		app.DrawUIText("- F10", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
+		helpY += float32(infoFont.RowHeight)
+		app.DrawUIText("export preset", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
+		app.DrawUIText("- E", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
+		helpY += float32(infoFont.RowHeight)
+		app.DrawUIText("copy share code", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
+		app.DrawUIText("- F5", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
+		helpY += float32(infoFont.RowHeight)
+		app.DrawUIText("paste share code", &infoFont, mgl32.Vec2{helpX, helpY}, helpColor, mgl32.Vec2{1, 0}, 0)
+		app.DrawUIText("- F6", &infoFont, mgl32.Vec2{helpX + 10, helpY}, helpColor, mgl32.Vec2{0, 0}, 0)
 
-		drawCells(cells, settings.Cells, cube)
+		// Instance data is cached by layers, it's only computed when their settings change.
+		for i := range layers {
+			layers[i].Draw(&settings.Layers[i], *gpuTransforms)
+		}
 		viewMatrix = camera.GetViewMatrix()
 		camera.Update(dt)
 		app.RenderScene(screenBuffer, sceneView, viewMatrix, projectionMatrix, &settings.Rendering)
 		
 		// SCREENSHOTS
 		if platform.IsKeyPressed(platform.KeyF10) {
-			screenshotTextTimer = screenshotTextDuration
+			noticeText, noticeTimer = "IMAGE SAVED", screenshotNoticeDuration
 
 			app.RenderScene(screenBuffer, screenshotSceneView, viewMatrix, projectionMatrixScreenshot, &settings.Rendering)
 			imageBytes, imageWidth, imageHeight := app.GetSceneBuffer(screenshotSceneView)
@@ -504,90 +1220,12 @@ func main() {
 		window.SwapBuffers()
 
 		settings.Camera.Radius, settings.Camera.Azimuth, settings.Camera.Polar, settings.Camera.Height = camera.GetState()
-		settings.Cells.RadiusMin = radiusMinCtrlToCell(innerCircleController.Radius.Val)
-		settings.Cells.RadiusMax = radiusMaxCtrlToCell(outerCircleController.Radius.Val)
-	}
-	app.SaveActiveSettings(settings)
-}
-
-var cubeVertices = [...]float32{
-	// FRONT
-	-0.5, -0.5, 0.5, 1.0,
-	0.0, 0.0, 1.0, 0.0,
-	-0.5, 0.5, 0.5, 1.0,
-	0.0, 0.0, 1.0, 0.0,
-	0.5, 0.5, 0.5, 1.0,
-	0.0, 0.0, 1.0, 0.0,
-	0.5, -0.5, 0.5, 1.0,
-	0.0, 0.0, 1.0, 0.0,
-
-	// LEFT
-	-0.5, -0.5, 0.5, 1.0,
-	-1.0, 0.0, 0.0, 0.0,
-	-0.5, 0.5, 0.5, 1.0,
-	-1.0, 0.0, 0.0, 0.0,
-	-0.5, 0.5, -0.5, 1.0,
-	-1.0, 0.0, 0.0, 0.0,
-	-0.5, -0.5, -0.5, 1.0,
-	-1.0, 0.0, 0.0, 0.0,
-
-	// RIGHT
-	0.5, -0.5, -0.5, 1.0,
-	1.0, 0.0, 0.0, 0.0,
-	0.5, 0.5, -0.5, 1.0,
-	1.0, 0.0, 0.0, 0.0,
-	0.5, 0.5, 0.5, 1.0,
-	1.0, 0.0, 0.0, 0.0,
-	0.5, -0.5, 0.5, 1.0,
-	1.0, 0.0, 0.0, 0.0,
-
-	// TOP
-	-0.5, 0.5, 0.5, 1.0,
-	0.0, 1.0, 0.0, 0.0,
-	-0.5, 0.5, -0.5, 1.0,
-	0.0, 1.0, 0.0, 0.0,
-	0.5, 0.5, -0.5, 1.0,
-	0.0, 1.0, 0.0, 0.0,
-	0.5, 0.5, 0.5, 1.0,
-	0.0, 1.0, 0.0, 0.0,
-
-	// BOTTOM
-	-0.5, -0.5, -0.5, 1.0,
-	0.0, -1.0, 0.0, 0.0,
-	-0.5, -0.5, 0.5, 1.0,
-	0.0, -1.0, 0.0, 0.0,
-	0.5, -0.5, 0.5, 1.0,
-	0.0, -1.0, 0.0, 0.0,
-	0.5, -0.5, -0.5, 1.0,
-	0.0, -1.0, 0.0, 0.0,
-
-	// BACK
-	0.5, -0.5, -0.5, 1.0,
-	0.0, 0.0, -1.0, 0.0,
-	0.5, 0.5, -0.5, 1.0,
-	0.0, 0.0, -1.0, 0.0,
-	-0.5, 0.5, -0.5, 1.0,
-	0.0, 0.0, -1.0, 0.0,
-	-0.5, -0.5, -0.5, 1.0,
-	0.0, 0.0, -1.0, 0.0,
-}
-
-var cubeIndices = [...]uint32{
-	0, 1, 2,
-	0, 2, 3,
 
-	4, 5, 6,
-	4, 6, 7,
-
-	8, 9, 10,
-	8, 10, 11,
-
-	12, 13, 14,
-	12, 14, 15,
-
-	16, 17, 18,
-	16, 18, 19,
-
-	20, 21, 22,
-	20, 22, 23,
+		// Changes are recorded once user lets go of the controls, so a drag is a single step.
+		history.Update(getTargetSettings(), platform.IsMouseLeftButtonDown() || ui.IsRegisteringInput)
+	}
+	err = app.SaveActiveSettings(settings)
+	if err != nil {
+		log.Println("couldn't save active settings:", err)
+	}
 }
@@ -0,0 +1,182 @@
+#version 420 core
+layout (location = 0) in vec4 in_position;
+layout (location = 1) in vec4 in_normal;
+// Normalized polar, azimuth and radius of the cell, followed by its color multiplier.
+layout (location = 2) in vec4 cell_coords;
+// Normalized width and depth of the cell, followed by its color index and phase of its animation.
+layout (location = 3) in vec4 cell_shape;
+
+out vec4 position;
+out vec4 normal;
+out vec4 in_color;
+
+uniform mat4 projection_matrix;
+uniform mat4 view_matrix;
+
+uniform float polar_std;
+uniform float polar_mean;
+uniform float radius_min;
+uniform float radius_max;
+uniform float height_ratio;
+
+// 0 - squared, 1 - linear, 2 - log-normal.
+uniform int scale_distribution;
+uniform vec2 width_range;
+uniform vec2 depth_range;
+uniform int uniform_scale;
+
+// Time of animation clock wrapped to ANIMATION_PERIOD and speed and amplitude of motions, see AnimationSettings.
+uniform float animation_time;
+uniform vec2 orbit;
+uniform vec2 breathe;
+uniform vec2 wave;
+uniform vec2 spin;
+
+uniform vec4 palette[16];
+uniform int palette_size;
+uniform vec4 previous_palette[16];
+uniform int previous_palette_size;
+uniform float palette_transition;
+
+const float MIN_SCALE = 0.001;
+const float PI = 3.14159265359;
+const float WAVE_LENGTH = 5.0;
+const float ANIMATION_PERIOD = 600.0;
+
+// Single precision approximation of inverse error function by M. Giles.
+float erfinv(float x)
+{
+	float w = -log((1.0 - x) * (1.0 + x));
+	float p;
+	if (w < 5.0) {
+		w = w - 2.5;
+		p = 2.81022636e-08;
+		p = 3.43273939e-07 + p * w;
+		p = -3.5233877e-06 + p * w;
+		p = -4.39150654e-06 + p * w;
+		p = 0.00021858087 + p * w;
+		p = -0.00125372503 + p * w;
+		p = -0.00417768164 + p * w;
+		p = 0.246640727 + p * w;
+		p = 1.50140941 + p * w;
+	} else {
+		w = sqrt(w) - 3.0;
+		p = -0.000200214257;
+		p = 0.000100950558 + p * w;
+		p = 0.00134934322 + p * w;
+		p = -0.00367342844 + p * w;
+		p = 0.00573950773 + p * w;
+		p = -0.0076224613 + p * w;
+		p = 0.00943887047 + p * w;
+		p = 1.00167406 + p * w;
+		p = 2.83297682 + p * w;
+	}
+	return p * x;
+}
+
+// Maps normalized dimension t into range, the same way getScale in cells.go does.
+float getScale(float t, vec2 scale_range)
+{
+	float minScale = max(scale_range.x, MIN_SCALE);
+	float maxScale = max(scale_range.y, MIN_SCALE);
+	if (scale_distribution == 1) {
+		return mix(minScale, maxScale, t);
+	} else if (scale_distribution == 2) {
+		float mean = (log(minScale) + log(maxScale)) / 2.0;
+		float std = (log(maxScale) - log(minScale)) / 4.0;
+		float normal_sample = sqrt(2.0) * erfinv(2.0 * clamp(t, 1e-6, 1.0 - 1e-6) - 1.0);
+		return clamp(exp(mean + std * normal_sample), min(minScale, maxScale), max(minScale, maxScale));
+	}
+	float scale = mix(sqrt(minScale), sqrt(maxScale), t);
+	return scale * scale;
+}
+
+mat4 rotateY(float angle)
+{
+	float c = cos(angle);
+	float s = sin(angle);
+	return mat4(
+		vec4(c, 0.0, -s, 0.0),
+		vec4(0.0, 1.0, 0.0, 0.0),
+		vec4(s, 0.0, c, 0.0),
+		vec4(0.0, 0.0, 0.0, 1.0));
+}
+
+// Rounds frequency of motion with speed to a whole number of cycles per ANIMATION_PERIOD,
+// the same way getMotionFrequency in cell_animation.go does.
+float getMotionFrequency(float speed)
+{
+	return floor(speed * ANIMATION_PERIOD + 0.5) / ANIMATION_PERIOD;
+}
+
+// Moves the cell the same way animateCellMatrix in cell_animation.go does.
+mat4 animate(mat4 model_matrix, float phase)
+{
+	if (spin.x != 0.0) {
+		float variation = 1.0 + spin.y * (2.0 * fract(phase * 7.0) - 1.0);
+		model_matrix = model_matrix * rotateY(2.0 * PI * fract(getMotionFrequency(spin.x * variation) * animation_time));
+	}
+	if (breathe.x != 0.0) {
+		float scale = max(0.0, 1.0 + breathe.y * sin(2.0 * PI * (getMotionFrequency(breathe.x) * animation_time + phase)));
+		model_matrix[0] *= scale;
+		model_matrix[1] *= scale;
+		model_matrix[2] *= scale;
+	}
+	if (wave.x != 0.0) {
+		vec3 cell_position = model_matrix[3].xyz;
+		float cell_distance = length(cell_position);
+		if (cell_distance > 1e-6) {
+			float offset = wave.y * sin(2.0 * PI * (getMotionFrequency(wave.x) * animation_time - cell_distance / WAVE_LENGTH));
+			model_matrix[3] = vec4(cell_position * (1.0 + offset / cell_distance), 1.0);
+		}
+	}
+	if (orbit.x != 0.0) {
+		float variation = 1.0 + orbit.y * (2.0 * fract(phase * 13.0) - 1.0);
+		model_matrix = rotateY(2.0 * PI * fract(getMotionFrequency(orbit.x * variation) * animation_time)) * model_matrix;
+	}
+	return model_matrix;
+}
+
+void main()
+{
+	// Place the cell, the same way iris distribution does.
+	float polar = cell_coords.x * polar_std + polar_mean;
+	float azimuth = cell_coords.y;
+	float radius = mix(radius_min, radius_max, cell_coords.z);
+	vec3 cell_position = vec3(sin(polar) * sin(azimuth), cos(polar), sin(polar) * cos(azimuth)) * radius;
+
+	// Cell faces the center of the scene.
+	vec3 forward = -cell_position;
+	if (length(forward) < 1e-6) {
+		forward = vec3(0, 0, -1);
+	}
+	forward = normalize(forward);
+	vec3 up = vec3(0, 1, 0);
+	if (length(cross(forward, up)) < 1e-3) {
+		up = vec3(0, 0, 1);
+	}
+	vec3 side = normalize(cross(forward, up));
+	up = cross(side, forward);
+
+	float width = getScale(cell_shape.x, width_range);
+	float depth = uniform_scale != 0 ? width : getScale(cell_shape.y, depth_range);
+	float height = (width + depth) / 2.0 * height_ratio;
+
+	mat4 model_matrix = mat4(
+		vec4(side * width, 0.0),
+		vec4(up * height, 0.0),
+		vec4(-forward * depth, 0.0),
+		vec4(cell_position, 1.0));
+	model_matrix = animate(model_matrix, cell_shape.w);
+
+	position = view_matrix * model_matrix * in_position;
+	normal = transpose(inverse(view_matrix * model_matrix)) * in_normal;
+	gl_Position = projection_matrix * position;
+
+	int color_index = int(cell_shape.z);
+	vec4 color = palette[color_index % palette_size];
+	if (palette_transition < 1.0) {
+		color = mix(previous_palette[color_index % previous_palette_size], color, palette_transition);
+	}
+	in_color = color * cell_coords.w;
+}